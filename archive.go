@@ -0,0 +1,123 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// archive is an OutputSink bundling the generated output into a single
+// .tar.gz or .zip file; it is safe for concurrent use
+type archive struct {
+	mu   sync.Mutex
+	file *os.File     // temporary file next to the destination, nil for stdout
+	buf  bytes.Buffer // the archive for stdout until it is complete
+	dest string
+	mode fileMode // see Config.OutputMode
+
+	gz  *gzip.Writer
+	tw  *tar.Writer
+	zw  *zip.Writer
+	now time.Time
+}
+
+// openArchive starts an archive for dest, whose format is chosen by its
+// suffix (.zip, otherwise .tar.gz); "-" writes a .tar.gz to stdout. Like
+// the other outputs the file is only replaced once the archive is complete,
+// and stdout only gets a complete archive.
+func openArchive(dest string, mode fileMode) (*archive, error) {
+	a := &archive{dest: dest, mode: mode, now: time.Now()}
+
+	var w io.Writer = &a.buf
+	if dest != "-" {
+		file, err := os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".*")
+		if err != nil {
+			return nil, err
+		}
+		a.file, w = file, file
+	}
+
+	if strings.HasSuffix(dest, ".zip") {
+		a.zw = zip.NewWriter(w)
+	} else {
+		a.gz = gzip.NewWriter(w)
+		a.tw = tar.NewWriter(a.gz)
+	}
+	return a, nil
+}
+
+// Write stores the content of r as the slash-separated path name
+func (a *archive) Write(name string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.zw != nil {
+		w, err := a.zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: a.now})
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}
+
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     int64(a.mode.perm()),
+		Size:     int64(len(data)),
+		ModTime:  a.now,
+	}
+	if err := a.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = a.tw.Write(data)
+	return err
+}
+
+// close finishes the archive; only if commit is set the destination is
+// replaced, otherwise the partial archive is discarded
+func (a *archive) close(commit bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var err error
+	if a.zw != nil {
+		err = a.zw.Close()
+	} else {
+		err = a.tw.Close()
+		if err2 := a.gz.Close(); err == nil {
+			err = err2
+		}
+	}
+	if a.file == nil {
+		if err != nil || !commit {
+			return err
+		}
+		_, err = os.Stdout.Write(a.buf.Bytes())
+		return err
+	}
+
+	defer os.Remove(a.file.Name())
+	if err2 := a.file.Close(); err == nil {
+		err = err2
+	}
+	if err != nil || !commit {
+		return err
+	}
+	if err := os.Chmod(a.file.Name(), a.mode.perm()); err != nil {
+		return err
+	}
+	return os.Rename(a.file.Name(), a.dest)
+}
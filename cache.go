@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// httpCache keeps the validators and bodies of earlier responses so that
+// requests can be made conditional; a nil *httpCache disables it. Entries are
+// independent files, so the directory can be deleted at any time.
+type httpCache struct {
+	dir  string
+	mode fileMode // see Config.OutputMode
+}
+
+// cacheEntry is a cached response, stored as JSON
+type cacheEntry struct {
+	Url          string     `json:"url"`
+	Form         url.Values `json:"form,omitempty"`
+	ETag         string     `json:"etag,omitempty"`
+	LastModified string     `json:"lastModified,omitempty"`
+	Body         []byte     `json:"body"` // as UTF-8
+}
+
+func (c *httpCache) filename(rawUrl string, data url.Values) string {
+	sum := sha256.Sum256([]byte(rawUrl + "\n" + data.Encode()))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// lookup returns the entry of the request, nil if there is none or it is
+// unreadable
+func (c *httpCache) lookup(rawUrl string, data url.Values) *cacheEntry {
+	if c == nil {
+		return nil
+	}
+	b, err := os.ReadFile(c.filename(rawUrl, data))
+	if err != nil {
+		return nil
+	}
+	var e cacheEntry
+	if err := json.Unmarshal(b, &e); err != nil || e.ETag == "" && e.LastModified == "" {
+		return nil
+	}
+	return &e
+}
+
+// condition adds the validators of e to req
+func (e *cacheEntry) condition(req *http.Request) {
+	if e == nil {
+		return
+	}
+	if e.ETag != "" {
+		req.Header.Set("If-None-Match", e.ETag)
+	}
+	if e.LastModified != "" {
+		req.Header.Set("If-Modified-Since", e.LastModified)
+	}
+}
+
+// store saves body if the response carries a validator; failures only cost
+// the next request its condition, so they are merely logged
+func (c *httpCache) store(rawUrl string, data url.Values, header http.Header, body []byte) {
+	if c == nil {
+		return
+	}
+	e := cacheEntry{
+		Url:          rawUrl,
+		Form:         data,
+		ETag:         header.Get("ETag"),
+		LastModified: header.Get("Last-Modified"),
+		Body:         body,
+	}
+	if e.ETag == "" && e.LastModified == "" {
+		return
+	}
+	b, err := json.Marshal(e)
+	if err == nil {
+		err = mkdirAll(c.dir, c.mode)
+	}
+	if err == nil {
+		err = writeFileAtomic(c.filename(rawUrl, data), b, c.mode)
+	}
+	if err != nil {
+		log.Println("http cache:", err)
+	}
+}
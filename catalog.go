@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+)
+
+// catalogEntry is the metadata of a canteen in catalog.json
+type catalogEntry struct {
+	Id        string            `json:"id"`
+	Name      string            `json:"name"`
+	Address   string            `json:"address,omitempty"`
+	City      string            `json:"city,omitempty"`
+	District  string            `json:"district,omitempty"`
+	Phone     string            `json:"phone,omitempty"`
+	Fax       string            `json:"fax,omitempty"`
+	Email     string            `json:"email,omitempty"`
+	Latitude  string            `json:"latitude,omitempty"`
+	Longitude string            `json:"longitude,omitempty"`
+	Hours     map[string]string `json:"hours,omitempty"` // weekday -> "HH:MM-HH:MM", closed days omitted
+
+	// SemesterBreak notes differing hours during the semester break
+	SemesterBreak *SemesterBreak `json:"semesterBreak,omitempty"`
+	Accessibility string         `json:"accessibility,omitempty"`
+	Transit       []string       `json:"transit,omitempty"`  // nearby stops
+	Operator      string         `json:"operator,omitempty"` // external operator only
+	Seats         int            `json:"seats,omitempty"`
+	Closure       string         `json:"closure,omitempty"` // banner of a long-term closure
+	Status        *catalogStatus `json:"status,omitempty"`  // banner of the day of the last run
+	Updated       string         `json:"updated,omitempty"` // latest "Stand" date of the meal pages
+
+	Metadata string `json:"metadata"` // URL of metadata.xml
+}
+
+// catalogStatus is the live banner of a canteen page on date
+type catalogStatus struct {
+	Date   string `json:"date"`
+	Status string `json:"status"`
+}
+
+var catalogDays = [7]string{"monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday"}
+
+// genCatalog writes the metadata of the canteens ids, in their order, to
+// catalog.json: as parsed in this run or else as in the previous catalog, so
+// canteens not processed (-since-id, -include-ids) or failing in this run
+// keep their entry. updated holds the latest "Stand" date of the meal pages
+// of the canteens by id, date is the day of the run.
+func (p *Parser) genCatalog(ids []string, metadata map[string]*Canteen, updated map[string]string, date string) error {
+	log.Println("generate", p.localPath(catalogFile), "(catalog)")
+
+	prev, err := p.loadCatalog()
+	if err != nil {
+		return err
+	}
+	entries := make([]catalogEntry, 0, len(ids))
+	for _, id := range ids {
+		if c := metadata[id]; c != nil {
+			e := p.catalogEntry(id, c, date)
+			if e.Updated = updated[id]; e.Updated == "" {
+				// the meal pages of the canteen failed or did not tell
+				e.Updated = prev[id].Updated
+			}
+			entries = append(entries, e)
+		} else if e, ok := prev[id]; ok {
+			entries = append(entries, e)
+		}
+	}
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return p.writeState(catalogFile, append(data, '\n'))
+}
+
+// loadCatalog returns the entries of the previous catalog.json by id
+func (p *Parser) loadCatalog() (map[string]catalogEntry, error) {
+	filename := p.localPath(catalogFile)
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var entries []catalogEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	byId := make(map[string]catalogEntry, len(entries))
+	for _, e := range entries {
+		byId[e.Id] = e
+	}
+	return byId, nil
+}
+
+// catalogEntry returns the entry of canteen id with metadata c parsed on
+// date
+func (p *Parser) catalogEntry(id string, c *Canteen, date string) catalogEntry {
+	e := catalogEntry{
+		Id:            id,
+		Name:          c.Name,
+		Address:       c.Address,
+		City:          c.City,
+		District:      c.District,
+		Phone:         c.Phone,
+		Fax:           c.Fax,
+		Email:         c.Email,
+		SemesterBreak: c.SemesterBreak,
+		Accessibility: c.Accessibility,
+		Transit:       c.Transit,
+		Operator:      c.Operator,
+		Seats:         c.Seats,
+		Closure:       c.Closure,
+		Metadata:      p.feedUrl(id, metadataFile),
+	}
+	if c.TodayStatus != "" {
+		e.Status = &catalogStatus{Date: date, Status: c.TodayStatus}
+	}
+	if c.Location != nil {
+		e.Latitude, e.Longitude = c.Location.Latitude, c.Location.Longitude
+	}
+	if c.Times != nil && len(c.Times.openingHours) == len(catalogDays) {
+		e.Hours = make(map[string]string)
+		for i, hours := range c.Times.openingHours {
+			if hours != "" {
+				e.Hours[catalogDays[i]] = hours
+			}
+		}
+	}
+	return e
+}
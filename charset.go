@@ -0,0 +1,43 @@
+package main
+
+import (
+	"log"
+	"mime"
+	"strings"
+	"unicode/utf8"
+)
+
+// cp1252 maps the bytes 0x80 to 0x9f of Windows-1252, where it differs from
+// ISO-8859-1; undefined bytes are kept as the C1 control of the same value
+var cp1252 = [32]rune{
+	'€', '\u0081', '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', '\u008d', 'Ž', '\u008f',
+	'\u0090', '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', '\u009d', 'ž', 'Ÿ',
+}
+
+// toUTF8 returns body as UTF-8. The html parser only understands UTF-8, so
+// pages served as ISO-8859-1 or Windows-1252 (declared or not, and at times
+// declared as UTF-8) would get mangled umlauts and euro signs. Such pages are
+// not valid UTF-8 and are decoded as Windows-1252, a superset of the printable
+// ISO-8859-1 characters; valid UTF-8 is kept whatever the declaration says.
+func toUTF8(rawUrl, contentType string, body []byte) []byte {
+	if utf8.Valid(body) {
+		return body
+	}
+
+	declared := "none"
+	if _, params, err := mime.ParseMediaType(contentType); err == nil && params["charset"] != "" {
+		declared = strings.ToLower(params["charset"])
+	}
+	log.Printf("%s: response is no valid UTF-8 (charset %s), decoding it as Windows-1252\n", rawUrl, declared)
+
+	var b strings.Builder
+	b.Grow(len(body) + len(body)/8)
+	for _, c := range body {
+		if c >= 0x80 && c < 0xa0 {
+			b.WriteRune(cp1252[c-0x80])
+		} else {
+			b.WriteRune(rune(c))
+		}
+	}
+	return []byte(b.String())
+}
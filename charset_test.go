@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// latin1 returns s encoded as ISO-8859-1, s must only hold its characters
+func latin1(s string) string {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		b = append(b, byte(r))
+	}
+	return string(b)
+}
+
+func TestToUTF8(t *testing.T) {
+	for _, test := range []struct {
+		body        string
+		contentType string
+		want        string
+	}{
+		{"Grüße", "text/html; charset=utf-8", "Grüße"},
+		{latin1("Grüße"), "text/html; charset=ISO-8859-1", "Grüße"},
+		{latin1("Käse"), "", "Käse"},
+		{"\x80 2,50 \x96 \x84Sp\xe4tzle\x93", "text/html; charset=windows-1252", "€ 2,50 – „Spätzle“"},
+		{"\x81\xe4", "text/html", "\u0081ä"},
+	} {
+		if got := string(toUTF8("test", test.contentType, []byte(test.body))); got != test.want {
+			t.Errorf("toUTF8(%q, %q) = %q, want %q", test.body, test.contentType, got, test.want)
+		}
+	}
+}
+
+// TestLatin1Page checks that the umlauts of a day page served as ISO-8859-1,
+// but declared as UTF-8, make it into the feed
+func TestLatin1Page(t *testing.T) {
+	site := newTestSite(t, "1")
+	site.day = latin1(strings.Replace(testDay, "Schnitzel", "Kässpätzle mit Röstzwiebeln", 1))
+	cfg := site.config(t)
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(cfg.OutputDir, "1", fullFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "<name>Kässpätzle mit Röstzwiebeln</name>") {
+		t.Errorf("feed lacks the meal:\n%s", data)
+	}
+}
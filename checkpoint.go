@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+)
+
+// checkpoint persists the last date up to which each canteen's feed has been
+// written, so an interrupted long backfill resumes with the first canteen
+// that is not done yet instead of starting over; a canteen interrupted
+// midway is fetched again completely
+type checkpoint struct {
+	mu    sync.Mutex
+	file  string
+	mode  fileMode
+	dates map[string]string // id -> last written date (YYYY-MM-DD)
+}
+
+// loadCheckpoint reads the checkpoint file, which may not exist yet and is
+// written with mode
+func loadCheckpoint(file string, mode fileMode) (*checkpoint, error) {
+	cp := &checkpoint{file: file, mode: mode, dates: make(map[string]string)}
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return cp, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &cp.dates); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// done reports whether the feed of id has already been written up to date
+func (cp *checkpoint) done(id, date string) bool {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.dates[id] >= date
+}
+
+// clear removes the checkpoint file once a run got through, so that the next
+// run starts over
+func (cp *checkpoint) clear() error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.dates = make(map[string]string)
+	if err := os.Remove(cp.file); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// mark records that the feed of id has been written up to date and saves
+// the checkpoint file
+func (cp *checkpoint) mark(id, date string) error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.dates[id] = date
+	data, err := json.MarshalIndent(cp.dates, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(cp.file, append(data, '\n'), cp.mode)
+}
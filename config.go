@@ -0,0 +1,526 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds all tunables of a run
+type Config struct {
+	MetaURL   string // metadata and listing endpoint
+	MealURL   string // meal plan endpoint
+	DefaultID string // canteen id used to fetch the listing
+	FeedBase  string // base URL under which the output directory is published
+	OutputDir string
+	// OutputLayout selects the file layout below OutputDir:
+	// "nested" (<id>/metadata.xml) or "flat" (<id>-metadata.xml)
+	OutputLayout string
+	// Archive is a .tar.gz or .zip file (or - for stdout) receiving all
+	// output instead of OutputDir; the files later runs read back (the ids
+	// files, index.json and catalog.json) are kept in OutputDir as well
+	Archive string
+	// OutputMode is the permission of generated files, directories get it
+	// plus x where readable; 0 keeps the mode of existing files, else 0666
+	// minus the umask as with os.Create
+	OutputMode fileMode
+
+	DaysBefore int // first day of the feed window relative to today
+	DaysAfter  int // last day of the feed window relative to today
+
+	MaxRetries     int
+	ListingRetries int // attempts for the listing, which the whole run depends on
+	MaxRequests    int // hard cap on HTTP requests per run, 0 disables it
+	Concurrency    int // canteens processed at the same time
+
+	RetryStep   time.Duration // backoff after the first failed attempt
+	RetryMax    time.Duration // cap of the backoff
+	RetryFactor float64       // growth of the backoff per attempt
+
+	// RetryEmptyMeals refetches a day without meals up to this many times if
+	// the canteen is open on it according to its hours; days of canteens
+	// without known hours are never refetched
+	RetryEmptyMeals int
+
+	RunTimeout     time.Duration // deadline of the whole run, 0 disables it
+	RequestTimeout time.Duration // deadline of a single attempt, 0 disables it
+	HTTPTimeout    time.Duration // timeout of the HTTP client per exchange, 0 disables it
+
+	FromIndex  bool   // process the canteens of the existing index.json instead of the listing
+	IncludeIds idList // only process these canteens
+	ExcludeIds idList // never process these canteens
+
+	IgnoreNotes idList // notes stripped from all meals, in the list syntax of ids
+	ImageNotes  bool   // add the URL of the dish photo as note of the meal
+
+	PriceRoleMap roleMap    // price column labels or positions to roles, on top of the defaults
+	PriceCounts  countRoles // roles of unlabeled prices by their number, on top of the defaults
+
+	ErrorsReport string // JSON file listing all errors of the run
+	RequestLog   string // JSON lines file recording every HTTP request
+	IconReport   string // JSON file listing every distinct meal icon
+	FetchDump    string // directory receiving the raw HTML of every canteen page
+	HTTPCache    string // directory of the responses used for conditional requests
+	EventLog     string // JSON lines file the id transitions of every run are appended to
+	Trace        string // JSON lines file receiving timing spans of fetches and writes
+	TraceOTLP    string // OTLP/HTTP traces endpoint receiving the same spans at the end of the run
+
+	SinceID bool // only process ids above the high-water mark of the previous run
+
+	ExcludeClosed bool // archive canteens whose page announces a long-term closure
+
+	// MaxIdsAll caps ids_all; archived ids beyond it are forgotten lowest
+	// first, 0 keeps all
+	MaxIdsAll int
+
+	NotifyURL string // POSTed to whenever a feed changes
+
+	// VerifyURLs HEAD-checks the published feed URLs after the run, all of
+	// them or VerifySample evenly spaced ones
+	VerifyURLs   bool
+	VerifySample int
+
+	TimesType string // type attribute of the emitted <times>
+
+	Stamp bool // mark every feed with the time and tool version it was generated with
+
+	Catalog bool // write the metadata of all current canteens to catalog.json
+
+	CompactNotes bool // non-standard: emit notes as a single attribute per meal
+
+	Strict    bool // fail the run on any parse warning
+	OmitFeeds bool // write metadata without feed references
+	ICS       bool // write the opening hours as calendar next to the metadata
+	TodayFeed bool // also write the feed "today" with the day of the run only
+
+	FeedSchedules feedSchedules // <schedule> of every feed type, by feed name
+
+	// MinMeals is the number of meals below which an open day is suspect,
+	// 0 disables the check
+	MinMeals int
+
+	NoMetadata    bool
+	EmitEmptyDays bool // emit days without meals as <closed/> instead of omitting them
+
+	// Checkpoint is a state file recording the feeds already written for
+	// the window, to resume interrupted backfills; it is removed once a run
+	// got through
+	Checkpoint string
+
+	// SingleCategoryName replaces the often generic name of a day's only
+	// category, "" keeps it
+	SingleCategoryName string
+
+	DedupeMeals bool // list a meal found at the same prices in several categories of a day only once
+
+	StrictClosedDetection bool // skip days without meals unless the page says so instead of emitting them closed
+
+	GoldenDir    string // re-parse saved fixtures instead of scraping
+	UpdateGolden bool
+}
+
+func defaultConfig() Config {
+	return Config{
+		MetaURL:        urlMeta,
+		MealURL:        urlMeal,
+		DefaultID:      defaultID,
+		FeedBase:       urlFeedBase,
+		OutputDir:      repo,
+		OutputLayout:   "nested",
+		DaysBefore:     -1,
+		DaysAfter:      21,
+		MaxRetries:     httpMaxRetries,
+		ListingRetries: 3 * httpMaxRetries,
+		RetryStep:      httpSleepStep,
+		RetryMax:       httpSleepMax,
+		RetryFactor:    2,
+		MaxRequests:    100000,
+		HTTPTimeout:    httpTimeout,
+		Concurrency:    1,
+
+		FeedSchedules: feedSchedules{
+			"full":  {Hour: "8", Retry: "45 3 1440"},
+			"today": {Hour: "8-14", Retry: "30 1"},
+		},
+
+		TimesType:     "opening",
+		EmitEmptyDays: true,
+	}
+}
+
+func parseFlags(args []string) (Config, error) {
+	cfg := defaultConfig()
+
+	fs := flag.NewFlagSet("openmensa-parser-berlin", flag.ContinueOnError)
+	fs.StringVar(&cfg.MetaURL, "url-meta", cfg.MetaURL, "metadata and listing endpoint")
+	fs.StringVar(&cfg.MealURL, "url-meal", cfg.MealURL, "meal plan endpoint")
+	fs.StringVar(&cfg.DefaultID, "default-id", cfg.DefaultID, "canteen id used to fetch the listing")
+	fs.StringVar(&cfg.FeedBase, "feed-base", cfg.FeedBase, "base URL the output directory is published under")
+	fs.StringVar(&cfg.OutputDir, "output", cfg.OutputDir, "output directory")
+	fs.StringVar(&cfg.OutputLayout, "output-layout", cfg.OutputLayout, "file layout of the output directory: nested or flat")
+	fs.Var(&cfg.OutputMode, "output-permissions", "octal permissions of generated files and directories, e.g. 0664 (default: 0666 minus the umask, existing files keep theirs)")
+	fs.StringVar(&cfg.Archive, "archive", cfg.Archive, "write all output into this .tar.gz or .zip file instead of the output directory, keeping only the ids files, index.json and catalog.json there for later runs (-: .tar.gz to stdout)")
+	fs.IntVar(&cfg.DaysBefore, "days-before", cfg.DaysBefore, "first day of the feed relative to today")
+	fs.IntVar(&cfg.DaysAfter, "days-after", cfg.DaysAfter, "last day of the feed relative to today")
+	fs.IntVar(&cfg.RetryEmptyMeals, "retry-on-empty-meals", cfg.RetryEmptyMeals, "fetch a day without meals again up to this many times (at most 3) if the opening hours of the canteen say it is open; needs the metadata")
+	fs.IntVar(&cfg.Concurrency, "concurrency", cfg.Concurrency, "number of canteens fetched at the same time")
+	fs.IntVar(&cfg.MaxRetries, "retries", cfg.MaxRetries, "maximum number of attempts per HTTP request")
+	fs.IntVar(&cfg.ListingRetries, "listing-retries", cfg.ListingRetries, "maximum number of attempts for the canteen listing")
+	fs.DurationVar(&cfg.RetryStep, "retry-step", cfg.RetryStep, "backoff after the first failed HTTP attempt")
+	fs.DurationVar(&cfg.RetryMax, "retry-max", cfg.RetryMax, "maximum backoff between HTTP attempts, Retry-After headers included")
+	fs.Float64Var(&cfg.RetryFactor, "retry-multiplier", cfg.RetryFactor, "factor the backoff grows by per failed attempt; the actual wait is a random fraction of it")
+	fs.IntVar(&cfg.MaxRequests, "max-requests", cfg.MaxRequests, "stop the run after this many HTTP requests (0: unlimited)")
+	fs.DurationVar(&cfg.RunTimeout, "run-timeout", cfg.RunTimeout, "abort the run after this duration (0: unlimited)")
+	fs.DurationVar(&cfg.RequestTimeout, "request-timeout", cfg.RequestTimeout, "give up a single HTTP attempt after this duration, capped by the remaining -run-timeout (0: unlimited)")
+	fs.DurationVar(&cfg.HTTPTimeout, "http-timeout", cfg.HTTPTimeout, "give up an HTTP exchange, reading the body included, after this duration; timeouts are retried like other transient errors (0: unlimited)")
+	fs.BoolVar(&cfg.FromIndex, "canteens-from-index", cfg.FromIndex, "process exactly the canteens of the existing index.json instead of fetching the listing")
+	fs.Var(&cfg.IncludeIds, "include-ids", "only process and index these canteen ids (comma-separated or @file)")
+	fs.Var(&cfg.ExcludeIds, "exclude-ids", "never process or index these canteen ids (comma-separated or @file)")
+	fs.Var(&cfg.IgnoreNotes, "ignore-notes", "strip these notes from all meals (comma-separated or @file)")
+	fs.BoolVar(&cfg.ImageNotes, "image-notes", cfg.ImageNotes, "add the URL of a meal's dish photo, if any, as note of the meal")
+	fs.Var(&cfg.PriceRoleMap, "price-role-map", "assign prices to roles by column label or position, e.g. Azubis=pupil,1=student,single=other (roles: student, employee, pupil, other)")
+	fs.Var(&cfg.PriceCounts, "price-count", "roles of a meal's unlabeled prices when there are this many, in page order, e.g. 4=student,pupil,employee,other or 2=student,other; repeatable, by default only 1 (other) and 3 (student, employee, other) prices are assigned")
+	fs.StringVar(&cfg.ErrorsReport, "errors-report", cfg.ErrorsReport, "write all errors of the run as JSON to this file")
+	fs.StringVar(&cfg.RequestLog, "request-log", cfg.RequestLog, "record every HTTP request as a JSON line in this file")
+	fs.StringVar(&cfg.IconReport, "probe-new-icons", cfg.IconReport, "write every distinct meal icon (known and unknown) with an example to this JSON file")
+	fs.StringVar(&cfg.Trace, "trace", cfg.Trace, "write OpenTelemetry-style spans of the listing, metadata, day fetches and writes as JSON lines to this file")
+	fs.StringVar(&cfg.TraceOTLP, "trace-otlp", cfg.TraceOTLP, "send the spans -trace writes to this OTLP/HTTP traces endpoint (e.g. http://localhost:4318/v1/traces) when the run ends, with or without -trace")
+	fs.StringVar(&cfg.EventLog, "events", cfg.EventLog, "append the canteens that are new, reappeared or archived in this run as JSON lines to this file")
+	fs.StringVar(&cfg.HTTPCache, "http-cache", cfg.HTTPCache, "keep ETag/Last-Modified and bodies of responses in this directory and make later requests conditional; feeds whose pages are all unchanged are kept as written before (safe to delete)")
+	fs.StringVar(&cfg.FetchDump, "fetch-dump", cfg.FetchDump, "save the raw HTML of every fetched canteen page below this directory as <endpoint>/<id>_<date>.html")
+	fs.BoolVar(&cfg.SinceID, "since-id", cfg.SinceID, "only generate canteens with ids above the high-water mark of the previous run")
+	fs.StringVar(&cfg.NotifyURL, "notify-url", cfg.NotifyURL, "POST {\"id\", \"url\"} to this URL whenever a feed changed")
+	fs.BoolVar(&cfg.VerifyURLs, "verify-urls", cfg.VerifyURLs, "after the run, check that the published feed URLs resolve (needs the output to be published already)")
+	fs.BoolVar(&cfg.ExcludeClosed, "exclude-closed-canteens", cfg.ExcludeClosed, "move canteens announcing a long-term closure (\"bis auf Weiteres geschlossen\") from the index to the archive")
+	fs.IntVar(&cfg.MaxIdsAll, "compact-ids-archive", cfg.MaxIdsAll, "keep at most this many ids in ids_all, dropping the lowest archived ids first (0: unbounded)")
+	fs.IntVar(&cfg.VerifySample, "verify-sample", cfg.VerifySample, "with -verify-urls: only check this many feed URLs (0: all)")
+	fs.StringVar(&cfg.TimesType, "times-type", cfg.TimesType, "type attribute of the emitted opening hours")
+	fs.BoolVar(&cfg.Catalog, "catalog", cfg.Catalog, "also write the metadata of all current canteens as JSON to catalog.json")
+	fs.BoolVar(&cfg.Stamp, "stamp", cfg.Stamp, "add a comment with generation time and tool version to every feed")
+	fs.BoolVar(&cfg.CompactNotes, "emit-notes-as-attributes", cfg.CompactNotes, "non-standard, for size-sensitive archives only: write the notes of a meal as one notes attribute instead of <note> elements")
+	fs.BoolVar(&cfg.Strict, "strict", cfg.Strict, "fail on any parse warning (unknown icons, unexpected prices, …)")
+	fs.IntVar(&cfg.MinMeals, "min-meals", cfg.MinMeals, "warn about open days with fewer meals than this (0: disabled)")
+	fs.BoolVar(&cfg.OmitFeeds, "omit-feeds", cfg.OmitFeeds, "write metadata.xml without feed references")
+	fs.Var(&cfg.FeedSchedules, "feed-schedule", "replace the <schedule> of a feed type, as name:attr=value;… with the attributes dayOfMonth, dayOfWeek, month, hour (required), minute and retry, e.g. 'full:hour=8;retry=45 3 1440' (default); repeatable, feed types: full, today")
+	fs.BoolVar(&cfg.TodayFeed, "today-feed", cfg.TodayFeed, "also write today.xml with the day of the run only, announced as feed today with its own schedule (default hourly 8-14)")
+	fs.BoolVar(&cfg.ICS, "ics", cfg.ICS, "also write the opening hours of each canteen as weekly recurring events to opening.ics")
+	fs.BoolVar(&cfg.NoMetadata, "no-metadata", cfg.NoMetadata, "do not regenerate existing metadata.xml files, only refresh feeds")
+	fs.StringVar(&cfg.Checkpoint, "checkpoint", cfg.Checkpoint, "record finished feeds in this file and skip them when rerun for the same window (resumes interrupted backfills, removed once a run got through; not with -archive)")
+	fs.BoolVar(&cfg.EmitEmptyDays, "emit-empty-days", cfg.EmitEmptyDays, "emit days without meals as closed (OpenMensa default); if false they are omitted")
+	fs.StringVar(&cfg.SingleCategoryName, "single-category-name", cfg.SingleCategoryName, "rename the category of days with only one category to this (OpenMensa requires a name)")
+	fs.BoolVar(&cfg.DedupeMeals, "dedupe-across-categories", cfg.DedupeMeals, "list meals appearing at the same prices in several categories of a day only in the first, merging their notes")
+	fs.BoolVar(&cfg.StrictClosedDetection, "strict-closed-detection", cfg.StrictClosedDetection, "only take days without meals as closed if the page says \"Kein Speisenangebot\"; other empty pages are skipped with a warning")
+	fs.StringVar(&cfg.GoldenDir, "compare-golden", cfg.GoldenDir, "re-parse the saved day pages in this directory and compare their feeds to the goldens")
+	fs.BoolVar(&cfg.UpdateGolden, "update", cfg.UpdateGolden, "with -compare-golden: rewrite the goldens")
+
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+	if fs.NArg() > 0 {
+		return cfg, fmt.Errorf("unexpected arguments: %q", fs.Args())
+	}
+
+	if cfg.OutputDir == "" {
+		return cfg, errors.New("-output must not be empty")
+	}
+	if cfg.OutputLayout != "nested" && cfg.OutputLayout != "flat" {
+		return cfg, fmt.Errorf("unknown -output-layout %q", cfg.OutputLayout)
+	}
+	if cfg.MinMeals < 0 {
+		return cfg, errors.New("-min-meals must not be negative")
+	}
+	if cfg.TimesType == "" {
+		return cfg, errors.New("-times-type must not be empty")
+	}
+	if cfg.DaysBefore > cfg.DaysAfter {
+		return cfg, fmt.Errorf("-days-before (%d) must not be after -days-after (%d)", cfg.DaysBefore, cfg.DaysAfter)
+	}
+	if cfg.MaxRetries < 1 {
+		return cfg, errors.New("-retries must be at least 1")
+	}
+	if cfg.ListingRetries < 1 {
+		return cfg, errors.New("-listing-retries must be at least 1")
+	}
+	if cfg.MaxRequests < 0 {
+		return cfg, errors.New("-max-requests must not be negative")
+	}
+	if cfg.RunTimeout < 0 || cfg.RequestTimeout < 0 || cfg.HTTPTimeout < 0 {
+		return cfg, errors.New("-run-timeout, -request-timeout and -http-timeout must not be negative")
+	}
+	if cfg.RetryStep < 0 || cfg.RetryMax < 0 {
+		return cfg, errors.New("-retry-step and -retry-max must not be negative")
+	}
+	if cfg.RetryFactor < 1 {
+		return cfg, errors.New("-retry-multiplier must be at least 1")
+	}
+	for _, id := range cfg.ExcludeIds {
+		for _, id2 := range cfg.IncludeIds {
+			if id == id2 {
+				return cfg, fmt.Errorf("id %s is both included and excluded", id)
+			}
+		}
+	}
+	if cfg.Archive != "" && cfg.NoMetadata {
+		// skipped metadata would be missing from the archive
+		return cfg, errors.New("-no-metadata cannot be combined with -archive")
+	}
+	if cfg.Archive != "" && cfg.Checkpoint != "" {
+		// canteens skipped as already done would be missing from the archive
+		return cfg, errors.New("-checkpoint cannot be combined with -archive")
+	}
+	if cfg.Archive != "" && cfg.NotifyURL != "" {
+		// without the feeds of the previous run every feed would count as
+		// changed
+		return cfg, errors.New("-notify-url cannot be combined with -archive")
+	}
+	if cfg.Catalog && cfg.NoMetadata {
+		// skipped metadata would be missing from the catalog
+		return cfg, errors.New("-no-metadata cannot be combined with -catalog")
+	}
+	if cfg.MaxIdsAll < 0 {
+		return cfg, errors.New("-compact-ids-archive must not be negative")
+	}
+	if cfg.RetryEmptyMeals < 0 || cfg.RetryEmptyMeals > 3 {
+		return cfg, errors.New("-retry-on-empty-meals must be between 0 and 3")
+	}
+	if cfg.RetryEmptyMeals > 0 && cfg.NoMetadata {
+		// without the opening hours no day is known to be open
+		return cfg, errors.New("-no-metadata cannot be combined with -retry-on-empty-meals")
+	}
+	if cfg.Concurrency < 1 {
+		return cfg, errors.New("-concurrency must be at least 1")
+	}
+	if cfg.VerifySample < 0 {
+		return cfg, errors.New("-verify-sample must not be negative")
+	}
+	if cfg.TodayFeed && (cfg.DaysBefore > 0 || cfg.DaysAfter < 0) {
+		return cfg, errors.New("-today-feed requires today within -days-before and -days-after")
+	}
+	if cfg.UpdateGolden && cfg.GoldenDir == "" {
+		return cfg, errors.New("-update requires -compare-golden")
+	}
+	return cfg, nil
+}
+
+// idList is a flag value holding comma-separated ids or, prefixed with @, the
+// name of a file with one id per line
+type idList []string
+
+func (l *idList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *idList) Set(value string) error {
+	var ids []string
+	if strings.HasPrefix(value, "@") {
+		lines, err := readLines(value[1:])
+		if err != nil {
+			return err
+		}
+		ids = lines
+	} else {
+		ids = strings.Split(value, ",")
+	}
+	for _, id := range ids {
+		if id = strings.TrimSpace(id); id != "" {
+			*l = append(*l, id)
+		}
+	}
+	return nil
+}
+
+// priceRoleNames are the roles OpenMensa knows
+var priceRoleNames = map[string]bool{"student": true, "employee": true, "pupil": true, "other": true}
+
+// roleMap is a flag value holding comma-separated key=role pairs
+type roleMap map[string]string
+
+func (m *roleMap) String() string {
+	var pairs []string
+	for key, role := range *m {
+		pairs = append(pairs, key+"="+role)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (m *roleMap) Set(value string) error {
+	if *m == nil {
+		*m = make(roleMap)
+	}
+	for _, pair := range strings.Split(value, ",") {
+		i := strings.IndexByte(pair, '=')
+		if i < 0 {
+			return fmt.Errorf("%q is not of the form key=role", pair)
+		}
+		key, role := strings.TrimSpace(pair[:i]), strings.TrimSpace(pair[i+1:])
+		if key == "" {
+			return fmt.Errorf("%q has no key", pair)
+		}
+		if !priceRoleNames[role] {
+			return fmt.Errorf("unknown role %q", role)
+		}
+		(*m)[key] = role
+	}
+	return nil
+}
+
+// countRoles is a flag value mapping a number of prices to their roles, set
+// once per number as n=role,...
+type countRoles map[int][]string
+
+func (c *countRoles) String() string {
+	var pairs []string
+	for n, roles := range *c {
+		pairs = append(pairs, strconv.Itoa(n)+"="+strings.Join(roles, ","))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, " ")
+}
+
+func (c *countRoles) Set(value string) error {
+	i := strings.IndexByte(value, '=')
+	if i < 0 {
+		return fmt.Errorf("%q is not of the form n=role,...", value)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(value[:i]))
+	if err != nil || n < 1 {
+		return fmt.Errorf("%q is no positive number of prices", value[:i])
+	}
+	roles := strings.Split(value[i+1:], ",")
+	if len(roles) != n {
+		return fmt.Errorf("%d prices need %d roles, got %d", n, n, len(roles))
+	}
+	seen := make(map[string]bool)
+	for j, role := range roles {
+		role = strings.TrimSpace(role)
+		if !priceRoleNames[role] {
+			return fmt.Errorf("unknown role %q", role)
+		}
+		if seen[role] {
+			return fmt.Errorf("role %s given twice for %d prices", role, n)
+		}
+		seen[role] = true
+		roles[j] = role
+	}
+	if *c == nil {
+		*c = make(countRoles)
+	}
+	(*c)[n] = roles
+	return nil
+}
+
+// feedTypes are the feeds written per canteen
+var feedTypes = map[string]bool{"full": true, "today": true}
+
+// scheduleLimits are the largest values of the cron-like schedule attributes
+var scheduleLimits = map[string]int{"minute": 59, "hour": 23, "dayOfMonth": 31, "month": 12, "dayOfWeek": 7}
+
+var (
+	reScheduleField = regexp.MustCompile(`^(\*|\d+(-\d+)?)(/\d+)?(,(\*|\d+(-\d+)?)(/\d+)?)*$`)
+	reScheduleRetry = regexp.MustCompile(`^[1-9]\d*( [1-9]\d*){0,2}$`)
+	reNumber        = regexp.MustCompile(`\d+`)
+)
+
+// feedSchedules is a flag value holding the schedule of each feed type, set
+// once per type as name:attr=value;…
+type feedSchedules map[string]FeedSchedule
+
+func (f *feedSchedules) String() string {
+	var schedules []string
+	for name, s := range *f {
+		schedules = append(schedules, fmt.Sprintf("%s:%+v", name, s))
+	}
+	sort.Strings(schedules)
+	return strings.Join(schedules, " ")
+}
+
+func (f *feedSchedules) Set(value string) error {
+	i := strings.IndexByte(value, ':')
+	if i < 0 {
+		return fmt.Errorf("%q is not of the form name:attr=value;…", value)
+	}
+	name := strings.TrimSpace(value[:i])
+	if !feedTypes[name] {
+		return fmt.Errorf("unknown feed type %q", name)
+	}
+
+	var s FeedSchedule
+	fields := map[string]*string{
+		"dayOfMonth": &s.DayOfMonth,
+		"dayOfWeek":  &s.DayOfWeek,
+		"month":      &s.Month,
+		"hour":       &s.Hour,
+		"minute":     &s.Minute,
+		"retry":      &s.Retry,
+	}
+	for _, pair := range strings.Split(value[i+1:], ";") {
+		j := strings.IndexByte(pair, '=')
+		if j < 0 {
+			return fmt.Errorf("%q is not of the form attr=value", pair)
+		}
+		attr, v := strings.TrimSpace(pair[:j]), strings.TrimSpace(pair[j+1:])
+		field, ok := fields[attr]
+		if !ok {
+			return fmt.Errorf("unknown schedule attribute %q", attr)
+		}
+		if attr == "retry" {
+			if !reScheduleRetry.MatchString(v) {
+				return fmt.Errorf("retry %q is not of the form \"interval [count [timeout]]\" in minutes", v)
+			}
+		} else {
+			if !reScheduleField.MatchString(v) {
+				return fmt.Errorf("%s %q is no cron-like value", attr, v)
+			}
+			for _, n := range reNumber.FindAllString(v, -1) {
+				if k, _ := strconv.Atoi(n); k > scheduleLimits[attr] {
+					return fmt.Errorf("%s %q exceeds %d", attr, v, scheduleLimits[attr])
+				}
+			}
+		}
+		*field = v
+	}
+	if s.Hour == "" {
+		return fmt.Errorf("schedule of %s has no hour", name)
+	}
+
+	if *f == nil {
+		*f = make(feedSchedules)
+	}
+	(*f)[name] = s
+	return nil
+}
+
+// of returns the schedule of feed type name, nil if there is none
+func (f feedSchedules) of(name string) *FeedSchedule {
+	s, ok := f[name]
+	if !ok {
+		return nil
+	}
+	return &s
+}
+
+// fileMode is a flag value holding octal permission bits like 0664
+type fileMode os.FileMode
+
+func (m *fileMode) String() string {
+	if *m == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%#o", uint32(*m))
+}
+
+func (m *fileMode) Set(value string) error {
+	n, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || n == 0 || n > 0777 {
+		return fmt.Errorf("%q is no octal permission between 1 and 0777", value)
+	}
+	*m = fileMode(n)
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseFlags(t *testing.T) {
+	cfg, err := parseFlags(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if def := defaultConfig(); cfg.OutputDir != def.OutputDir || cfg.MaxRetries != def.MaxRetries || cfg.DaysAfter != def.DaysAfter {
+		t.Errorf("defaults %+v", cfg)
+	}
+
+	for _, test := range []struct {
+		args []string
+		err  string // part of the error, empty if the flags are valid
+	}{
+		{[]string{"-output", "out", "-days-before", "-1", "-days-after", "3"}, ""},
+		{[]string{"-archive", "run.tar.gz", "-catalog"}, ""},
+		{[]string{"surplus"}, "unexpected arguments"},
+		{[]string{"-output", ""}, "-output"},
+		{[]string{"-output-layout", "deep"}, "-output-layout"},
+		{[]string{"-min-meals", "-1"}, "-min-meals"},
+		{[]string{"-times-type", ""}, "-times-type"},
+		{[]string{"-days-before", "2", "-days-after", "1"}, "-days-before"},
+		{[]string{"-retries", "0"}, "-retries"},
+		{[]string{"-listing-retries", "0"}, "-listing-retries"},
+		{[]string{"-max-requests", "-1"}, "-max-requests"},
+		{[]string{"-request-timeout", "-1s"}, "-request-timeout"},
+		{[]string{"-retry-max", "-1s"}, "-retry-max"},
+		{[]string{"-include-ids", "1,2", "-exclude-ids", "2"}, "both included and excluded"},
+		{[]string{"-archive", "run.tar.gz", "-no-metadata"}, "-no-metadata"},
+		{[]string{"-archive", "run.tar.gz", "-notify-url", "http://hook.example/"}, "-notify-url"},
+		{[]string{"-archive", "run.tar.gz", "-checkpoint", "checkpoint.json"}, "-checkpoint"},
+		{[]string{"-catalog", "-no-metadata"}, "-catalog"},
+		{[]string{"-compact-ids-archive", "-1"}, "-compact-ids-archive"},
+		{[]string{"-retry-on-empty-meals", "4"}, "-retry-on-empty-meals"},
+		{[]string{"-retry-on-empty-meals", "1", "-no-metadata"}, "-retry-on-empty-meals"},
+		{[]string{"-concurrency", "0"}, "-concurrency"},
+		{[]string{"-verify-sample", "-1"}, "-verify-sample"},
+		{[]string{"-today-feed", "-days-before", "1", "-days-after", "3"}, "-today-feed"},
+		{[]string{"-update"}, "-compare-golden"},
+		{[]string{"-price-role-map", "1=teacher"}, "teacher"},
+		{[]string{"-price-count", "4=student,pupil,employee,other", "-price-count", "2=student,other"}, ""},
+		{[]string{"-price-count", "4=student,pupil,employee"}, "need 4 roles"},
+		{[]string{"-price-count", "2=student,student"}, "student"},
+		{[]string{"-price-count", "0="}, "positive number"},
+		{[]string{"-price-count", "2=student,teacher"}, "teacher"},
+	} {
+		_, err := parseFlags(test.args)
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%q: %s", test.args, err)
+		case test.err != "" && err == nil:
+			t.Errorf("%q: accepted", test.args)
+		case test.err != "" && !strings.Contains(err.Error(), test.err):
+			t.Errorf("%q: error %q does not mention %s", test.args, err, test.err)
+		}
+	}
+}
+
+// TestPriceCount checks that a -price-count mapping of four unlabeled prices
+// assigns their roles in page order
+func TestPriceCount(t *testing.T) {
+	cfg, err := parseFlags([]string{"-price-count", "4=student,pupil,employee,other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := strings.Replace(testDay, "€ 1,95/3,10/4,65", "€ 1,95/1,50/3,10/4,65", 1)
+	for _, test := range []struct {
+		cfg  Config
+		want string
+	}{
+		{cfg, "student=1.95 pupil=1.50 employee=3.10 other=4.65"},
+		{defaultConfig(), ""},
+	} {
+		d := NewParser(test.cfg).parseDay("1", "2026-10-14", parseDoc(t, day))
+		if len(d.Categories) != 1 || len(d.Categories[0].Meals) != 1 {
+			t.Fatalf("parsed %+v", d)
+		}
+		var got []string
+		for _, price := range d.Categories[0].Meals[0].Prices {
+			got = append(got, price.Role+"="+price.Price)
+		}
+		if strings.Join(got, " ") != test.want {
+			t.Errorf("prices %q, want %q", got, test.want)
+		}
+	}
+}
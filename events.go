@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+)
+
+// idEvent is one line of the event log, recording a change of the listing
+type idEvent struct {
+	Id        string    `json:"id"`
+	Event     string    `json:"event"` // "new", "reappeared" or "archived"
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// idEvents returns the events of a run at t, new canteens first, each kind
+// in id order
+func idEvents(t time.Time, added, reappeared, archived []string) []idEvent {
+	var events []idEvent
+	for _, kind := range []struct {
+		event string
+		ids   []string
+	}{{"new", added}, {"reappeared", reappeared}, {"archived", archived}} {
+		for _, id := range kind.ids {
+			events = append(events, idEvent{Id: id, Event: kind.event, Timestamp: t.UTC().Truncate(time.Second)})
+		}
+	}
+	return events
+}
+
+// appendEvents appends events to filename in a single write, so that the
+// lines of concurrent runs do not interleave
+func appendEvents(filename string, events []idEvent, mode fileMode) error {
+	if len(events) == 0 {
+		return nil
+	}
+	log.Println("append", len(events), "events to", filename)
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+
+	file, err := openFile(filename, os.O_APPEND, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
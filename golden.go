@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// compareGolden re-parses every saved day page dir/<id>_<date>.html and
+// writes its feed to the output directory as <id>_<date>.xml, reporting
+// whether it differs from the committed golden dir/<id>_<date>.xml. With
+// update the goldens themselves are (re)written instead.
+func compareGolden(p *Parser, dir string, update bool) error {
+	fixtures, err := filepath.Glob(filepath.Join(dir, "*.html"))
+	if err != nil {
+		return err
+	}
+	if len(fixtures) == 0 {
+		return fmt.Errorf("no *.html fixtures in %s", dir)
+	}
+
+	var mismatches int
+	for _, fixture := range fixtures {
+		name := strings.TrimSuffix(filepath.Base(fixture), ".html")
+		id, date := name, ""
+		if i := strings.LastIndexByte(name, '_'); i >= 0 {
+			id, date = name[:i], name[i+1:]
+		}
+
+		// fixtures saved by -fetch-dump hold the raw response
+		body, err := os.ReadFile(fixture)
+		if err != nil {
+			return err
+		}
+		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(toUTF8(fixture, "", body)))
+		if err != nil {
+			return fmt.Errorf("%s: %w", fixture, err)
+		}
+
+		var buf bytes.Buffer
+		c := &Canteen{Days: []Day{p.parseDay(id, date, doc)}, CompactNotes: p.cfg.CompactNotes}
+		if err := c.Write(&buf); err != nil {
+			return err
+		}
+
+		golden := filepath.Join(dir, name+".xml")
+		if update {
+			log.Println("update", golden)
+			if err := writeFileAtomic(golden, buf.Bytes(), p.cfg.OutputMode); err != nil {
+				return err
+			}
+			continue
+		}
+
+		out := filepath.Join(p.cfg.OutputDir, name+".xml")
+		if err := writeFileAtomic(out, buf.Bytes(), p.cfg.OutputMode); err != nil {
+			return err
+		}
+		want, err := os.ReadFile(golden)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(want, buf.Bytes()) {
+			log.Printf("%s: differs from %s\n", out, golden)
+			mismatches++
+		}
+	}
+
+	if mismatches > 0 {
+		return fmt.Errorf("%d of %d fixtures differ from their goldens", mismatches, len(fixtures))
+	}
+	log.Printf("all %d fixtures match their goldens\n", len(fixtures))
+	return nil
+}
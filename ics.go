@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// icsTimezone describes Europe/Berlin for calendar clients that do not know
+// the TZID
+const icsTimezone = "BEGIN:VTIMEZONE\r\n" +
+	"TZID:Europe/Berlin\r\n" +
+	"BEGIN:DAYLIGHT\r\n" +
+	"TZOFFSETFROM:+0100\r\n" +
+	"TZOFFSETTO:+0200\r\n" +
+	"TZNAME:CEST\r\n" +
+	"DTSTART:19700329T020000\r\n" +
+	"RRULE:FREQ=YEARLY;BYMONTH=3;BYDAY=-1SU\r\n" +
+	"END:DAYLIGHT\r\n" +
+	"BEGIN:STANDARD\r\n" +
+	"TZOFFSETFROM:+0200\r\n" +
+	"TZOFFSETTO:+0100\r\n" +
+	"TZNAME:CET\r\n" +
+	"DTSTART:19701025T030000\r\n" +
+	"RRULE:FREQ=YEARLY;BYMONTH=10;BYDAY=-1SU\r\n" +
+	"END:STANDARD\r\n" +
+	"END:VTIMEZONE\r\n"
+
+// icsDays are the iCalendar weekdays in the order of Times.openingHours
+var icsDays = [7]string{"MO", "TU", "WE", "TH", "FR", "SA", "SU"}
+
+// icsEscape escapes text for iCalendar TEXT values
+func icsEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(s)
+}
+
+// icsLine writes a content line to buf, folded at 75 octets as RFC 5545
+// requires; a fold never splits a UTF-8 sequence
+func icsLine(buf *bytes.Buffer, line string) {
+	const limit = 75
+	for n := limit; len(line) > n; n = limit - 1 { // continuations start with a space
+		cut := n
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		buf.WriteString(line[:cut])
+		buf.WriteString("\r\n ")
+		line = line[cut:]
+	}
+	buf.WriteString(line)
+	buf.WriteString("\r\n")
+}
+
+// genICS renders the opening hours of canteen c as a calendar with one
+// weekly recurring event per day and interval ("11:00-14:30", several
+// separated by commas), starting in the week of anchor; closed days have no
+// event. It returns nil if the hours are unknown. The calendar only depends
+// on the week of anchor, so it changes at most once a week.
+func genICS(id string, c *Canteen, anchor time.Time) ([]byte, error) {
+	if c.Times == nil || len(c.Times.openingHours) != len(icsDays) {
+		return nil, nil
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//openmensa-parser-berlin//opening hours//DE\r\n")
+	icsLine(&buf, "X-WR-CALNAME:"+icsEscape(c.Name))
+	buf.WriteString(icsTimezone)
+
+	// Monday of the week of anchor, which is also the DTSTAMP of the events
+	monday := anchor.AddDate(0, 0, -(int(anchor.Weekday())+6)%7)
+	stamp := monday.Format("20060102") + "T000000Z"
+	for i, hours := range c.Times.openingHours {
+		if hours == "" {
+			continue
+		}
+		day := monday.AddDate(0, 0, i).Format("20060102")
+		for j, interval := range strings.Split(hours, ",") {
+			bounds := strings.SplitN(strings.TrimSpace(interval), "-", 2)
+			if len(bounds) != 2 {
+				return nil, fmt.Errorf("%s: unexpected opening hours `%s`", id, hours)
+			}
+			from, to := bounds[0], bounds[1]
+			buf.WriteString("BEGIN:VEVENT\r\n")
+			icsLine(&buf, fmt.Sprintf("UID:%s-%s-%d@openmensa-parser-berlin", id, icsDays[i], j))
+			icsLine(&buf, "DTSTAMP:"+stamp)
+			icsLine(&buf, fmt.Sprintf("DTSTART;TZID=Europe/Berlin:%sT%s00", day, strings.ReplaceAll(from, ":", "")))
+			icsLine(&buf, fmt.Sprintf("DTEND;TZID=Europe/Berlin:%sT%s00", day, strings.ReplaceAll(to, ":", "")))
+			icsLine(&buf, "RRULE:FREQ=WEEKLY;BYDAY="+icsDays[i])
+			icsLine(&buf, "SUMMARY:"+icsEscape(c.Name+" geöffnet"))
+			buf.WriteString("END:VEVENT\r\n")
+		}
+	}
+	buf.WriteString("END:VCALENDAR\r\n")
+	return buf.Bytes(), nil
+}
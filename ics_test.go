@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestGenICS(t *testing.T) {
+	c := &Canteen{
+		Name:  "Mensa Charlottenburg, Hardenbergstraße",
+		Times: &Times{openingHours: []string{"11:00-14:30", "11:00-14:30, 17:00-19:00", "", "", "", "", ""}},
+	}
+	// a Wednesday afternoon
+	anchor := time.Date(2026, 10, 14, 15, 4, 5, 0, time.Local)
+	ics, err := genICS("321", c, anchor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"DTSTART;TZID=Europe/Berlin:20261012T110000\r\nDTEND;TZID=Europe/Berlin:20261012T143000\r\nRRULE:FREQ=WEEKLY;BYDAY=MO\r\n",
+		"UID:321-TU-1@openmensa-parser-berlin\r\nDTSTAMP:20261012T000000Z\r\nDTSTART;TZID=Europe/Berlin:20261013T170000\r\n",
+	} {
+		if !bytes.Contains(ics, []byte(want)) {
+			t.Errorf("missing %q in\n%s", want, ics)
+		}
+	}
+	if n := bytes.Count(ics, []byte("BEGIN:VEVENT")); n != 3 {
+		t.Errorf("%d events, want 3", n)
+	}
+	if bytes.Contains(ics, []byte("BYDAY=WE")) {
+		t.Error("event on a closed day")
+	}
+
+	// only the week of the anchor matters
+	later, err := genICS("321", c, anchor.AddDate(0, 0, 2).Add(3*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ics, later) {
+		t.Error("calendar differs within the same week")
+	}
+}
+
+func TestICSFolding(t *testing.T) {
+	c := &Canteen{
+		Name:  strings.Repeat("Mensa Süd ", 20),
+		Times: &Times{openingHours: []string{"11:00-14:30", "", "", "", "", "", ""}},
+	}
+	ics, err := genICS("321", c, time.Date(2026, 10, 14, 0, 0, 0, 0, time.Local))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(ics), "\r\n"), "\r\n")
+	var unfolded []string
+	for _, line := range lines {
+		if len(line) > 75 {
+			t.Errorf("line of %d octets: %q", len(line), line)
+		}
+		if !utf8.ValidString(line) {
+			t.Errorf("fold splits a character: %q", line)
+		}
+		if strings.HasPrefix(line, " ") {
+			unfolded[len(unfolded)-1] += line[1:]
+		} else {
+			unfolded = append(unfolded, line)
+		}
+	}
+	want := "SUMMARY:" + icsEscape(c.Name+" geöffnet")
+	found := false
+	for _, line := range unfolded {
+		found = found || line == want
+	}
+	if !found {
+		t.Errorf("no %q after unfolding", want)
+	}
+}
+
+// TestICSDST checks that the events keep their wall-clock times in the weeks
+// of the DST changes and that the week starts on its Monday
+func TestICSDST(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	c := &Canteen{
+		Name:  "Mensa",
+		Times: &Times{openingHours: []string{"11:00-14:30", "", "", "", "", "", "11:00-14:30"}},
+	}
+	for _, test := range []struct {
+		anchor time.Time
+		monday string
+		sunday string
+	}{
+		{time.Date(2026, 3, 29, 23, 30, 0, 0, berlin), "20260323", "20260329"},
+		{time.Date(2026, 3, 30, 0, 30, 0, 0, berlin), "20260330", "20260405"},
+		{time.Date(2026, 10, 25, 2, 30, 0, 0, berlin), "20261019", "20261025"},
+		{time.Date(2026, 10, 26, 0, 0, 0, 0, berlin), "20261026", "20261101"},
+	} {
+		ics, err := genICS("1", c, test.anchor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{
+			"DTSTAMP:" + test.monday + "T000000Z\r\n",
+			"DTSTART;TZID=Europe/Berlin:" + test.monday + "T110000\r\nDTEND;TZID=Europe/Berlin:" + test.monday + "T143000\r\n",
+			"DTSTART;TZID=Europe/Berlin:" + test.sunday + "T110000\r\nDTEND;TZID=Europe/Berlin:" + test.sunday + "T143000\r\n",
+		} {
+			if !bytes.Contains(ics, []byte(want)) {
+				t.Errorf("%s: missing %q in\n%s", test.anchor, want, ics)
+			}
+		}
+	}
+}
@@ -2,18 +2,35 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math"
+	"net"
 	"net/http"
+	"net/mail"
 	"net/url"
 	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
 	"regexp"
+	"runtime/debug"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/mpvl/unique"
@@ -27,68 +44,403 @@ const (
 
 	urlFeedBase = "https://raw.githubusercontent.com/escrl/openmensa-feed-berlin/master/"
 
-	repo           = "berlin/"
-	idsArchiveFile = repo + "ids_archive"
-	idsAllFile     = repo + "ids_all"
-	idsCurFile     = repo + "ids_current"
-	indexFile      = repo + "index.json"
+	repo           = "berlin"
+	idsArchiveFile = "ids_archive"
+	idsAllFile     = "ids_all"
+	idsCurFile     = "ids_current"
+	idsMarkFile    = "ids_highwater"
+	indexFile      = "index.json"
+	catalogFile    = "catalog.json"
+	metadataFile   = "metadata.xml"
+	fullFile       = "full.xml"
+	todayFile      = "today.xml"
+	icsFile        = "opening.ics"
 
 	httpMaxRetries = 10
 	httpSleepStep  = time.Second
+	httpSleepMax   = time.Minute
+	httpTimeout    = 30 * time.Second
 )
 
-func getHttpDoc(url string, data url.Values) *goquery.Document {
-	for i := 1; i <= httpMaxRetries; i++ {
-		resp, err := http.PostForm(url, data)
+// retryDelay is the time to wait after the given (1-based) failed attempt;
+// all backoff computation goes through here so it can be controlled in one
+// place. The backoff grows exponentially from RetryStep by RetryFactor up to
+// RetryMax, and a random wait up to it is taken (full jitter) so that
+// concurrent workers do not retry in lockstep.
+func (p *Parser) retryDelay(attempt int) time.Duration {
+	backoff := float64(p.cfg.RetryStep) * math.Pow(p.cfg.RetryFactor, float64(attempt-1))
+	if backoff > float64(p.cfg.RetryMax) {
+		backoff = float64(p.cfg.RetryMax)
+	}
+	if backoff < 1 {
+		return 0
+	}
+	return time.Duration(p.jitter(int64(backoff)))
+}
+
+// version is set at build time with -ldflags "-X main.version=..."
+var version string
+
+// toolVersion returns version or, without it, the module version the binary
+// was built from
+func toolVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "unknown"
+}
+
+// errRequestCap is returned by getHttpDoc once the parser has issued
+// MaxRequests requests
+var errRequestCap = errors.New("request cap reached")
+
+// abortsRun reports whether err stops the whole run instead of only the
+// canteen it occurred for
+func abortsRun(err error) bool {
+	return errors.Is(err, errRequestCap) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// retryWait waits for d after the failed attempt of attempts unless it was the
+// last one, there is no retry to wait for then
+func (p *Parser) retryWait(ctx context.Context, attempt, attempts int, d time.Duration) error {
+	if attempt >= attempts {
+		return nil
+	}
+	return p.sleep(ctx, d)
+}
+
+// sleep waits for d before a retry unless ctx is done
+func (p *Parser) sleep(ctx context.Context, d time.Duration) error {
+	atomic.AddInt64(&p.retries, 1)
+	atomic.AddInt64(&p.backoff, int64(d))
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// parseRetryAfter returns the wait requested by a Retry-After header, given
+// in seconds or as HTTP date relative to now
+func parseRetryAfter(header string, now time.Time) (time.Duration, bool) {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	t, err := http.ParseTime(header)
+	if err != nil {
+		return 0, false
+	}
+	if d := t.Sub(now); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
+
+// throttleDelay is the time to wait after the given (1-based) throttled
+// attempt: the one asked for by the Retry-After header if valid, else
+// retryDelay. The header gets no more than RetryMax, nor than what is left
+// until the deadline of ctx.
+func (p *Parser) throttleDelay(ctx context.Context, attempt int, retryAfter string) time.Duration {
+	now := time.Now()
+	d, ok := parseRetryAfter(retryAfter, now)
+	if !ok {
+		return p.retryDelay(attempt)
+	}
+	if d > p.cfg.RetryMax {
+		d = p.cfg.RetryMax
+	}
+	if deadline, ok := ctx.Deadline(); ok && d > deadline.Sub(now) {
+		d = deadline.Sub(now)
+	}
+	if d < 0 {
+		d = 0
+	}
+	return d
+}
+
+// permanentError reports whether a transport error will not go away within
+// the run (e.g. an unknown host), so retrying it only wastes the budget;
+// timeouts and connection resets are considered transient
+func permanentError(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsNotFound
+	}
+	return false
+}
+
+// getHttpDoc POSTs data to url and parses the response, retrying transient
+// failures; it returns promptly with ctx.Err() once ctx is done
+func (p *Parser) getHttpDoc(ctx context.Context, url string, data url.Values) (*goquery.Document, error) {
+	return p.getHttpDocRetries(ctx, url, data, p.cfg.MaxRetries)
+}
+
+// getHttpDocRetries is getHttpDoc with a retry budget of its own
+func (p *Parser) getHttpDocRetries(ctx context.Context, url string, data url.Values, retries int) (*goquery.Document, error) {
+	doc, _, err := p.fetchDoc(ctx, url, data, retries)
+	return doc, err
+}
+
+// fetchDoc is getHttpDocRetries also reporting whether the page was taken
+// from the http cache after a 304 Not Modified
+func (p *Parser) fetchDoc(ctx context.Context, url string, data url.Values, retries int) (*goquery.Document, bool, error) {
+	for i := 1; i <= retries; i++ {
+		if n := atomic.AddInt64(&p.requests, 1); p.cfg.MaxRequests > 0 && n > int64(p.cfg.MaxRequests) {
+			return nil, false, errRequestCap
+		}
+		doc, notModified, delay, err := p.fetchAttempt(ctx, url, data, i, retries)
+		if doc != nil || err != nil {
+			return doc, notModified, err
+		}
+		if err := p.retryWait(ctx, i, retries, delay); err != nil {
+			return nil, false, err
+		}
+	}
+	return nil, false, fmt.Errorf("aborting after %d retries for POST fetch at %s with %s", retries, url, data)
+}
+
+// fetchAttempt makes the attempt-th of attempts requests for fetchDoc; without
+// a document or an error, the request is to be retried after delay
+func (p *Parser) fetchAttempt(ctx context.Context, url string, data url.Values, attempt, attempts int) (*goquery.Document, bool, time.Duration, error) {
+	// an attempt ends at its own timeout or the deadline of the run,
+	// whichever comes first
+	var reqCtx context.Context
+	var cancel context.CancelFunc
+	if p.cfg.RequestTimeout > 0 {
+		reqCtx, cancel = context.WithTimeout(ctx, p.cfg.RequestTimeout)
+	} else {
+		reqCtx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodPost, url, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, false, 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	cached := p.cache.lookup(url, data)
+	cached.condition(req)
+
+	start := time.Now()
+	resp, err := p.client.Do(req)
+	p.requestLog.log(start, http.MethodPost, url, data, attempt, resp, err)
+	if ctx.Err() != nil {
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return nil, false, 0, ctx.Err()
+	}
+	if err != nil {
+		log.Println(err)
+		if permanentError(err) {
+			return nil, false, 0, err
+		}
+		return nil, false, p.retryDelay(attempt), nil
+	}
+	defer resp.Body.Close()
+	if final := resp.Request.URL; final.Host != req.URL.Host {
+		// e.g. a cookie consent or login wall in front of the site,
+		// whose page must not be parsed as meals
+		return nil, false, 0, fmt.Errorf("%s: redirected to %s", url, final)
+	}
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(cached.Body))
+		return doc, err == nil, 0, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		// read the whole body first: a connection dropped mid-response
+		// must be retried rather than parsed as a partial page
+		body, err := io.ReadAll(resp.Body)
+		if err == nil && resp.ContentLength >= 0 && int64(len(body)) != resp.ContentLength {
+			err = fmt.Errorf("got %d of %d bytes", len(body), resp.ContentLength)
+		}
 		if err != nil {
-			log.Println(resp)
-			log.Println(err)
-			// panic(err)
-			sleepTime := time.Duration(i) * httpSleepStep
-			time.Sleep(sleepTime)
-			continue
+			log.Printf("%s: truncated response: %s\n", url, err)
+			return nil, false, p.retryDelay(attempt), nil
 		}
-		if resp.StatusCode == http.StatusOK {
-			doc, err := goquery.NewDocumentFromResponse(resp)
-			if err != nil {
-				panic(err)
+
+		// the dump keeps the raw bytes, undecodable ones included
+		if p.cfg.FetchDump != "" {
+			p.dumpResponse(url, data, body)
+		}
+		body = toUTF8(url, resp.Header.Get("Content-Type"), body)
+
+		// a body the parser rejects is most likely corrupt as well
+		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+		if err != nil {
+			log.Printf("%s: unparsable response: %s\n", url, err)
+			return nil, false, p.retryDelay(attempt), nil
+		}
+		// a wall served in place of the page is treated like a redirect
+		// to another host: neither retried nor cached
+		if consentWall(doc) {
+			return nil, false, 0, fmt.Errorf("%s: got a cookie consent page instead of the site", url)
+		}
+		p.cache.store(url, data, resp.Header, body)
+		return doc, false, 0, nil
+	}
+	// not bandwidth limit exceeded (inofficial)
+	if resp.StatusCode == 509 { //|| resp.StatusCode == 500 {
+		// like retries, only counted when another attempt follows
+		if attempt < attempts {
+			atomic.AddInt64(&p.throttled, 1)
+		}
+		return nil, false, p.throttleDelay(ctx, attempt, resp.Header.Get("Retry-After")), nil
+	}
+	return nil, false, 0, fmt.Errorf("%s: got status code %d", url, resp.StatusCode)
+}
+
+// dumpResponse saves the body of a canteen's page as
+// FetchDump/<endpoint>/<id>_<date>.html (<id>.html without a date),
+// so a day page directory can be replayed with -compare-golden; pages not
+// belonging to a canteen are not saved
+func (p *Parser) dumpResponse(rawUrl string, data url.Values, body []byte) {
+	id := data.Get("resources_id")
+	if checkId(id) != nil {
+		return
+	}
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		log.Printf("%s: dump: %s\n", id, err)
+		return
+	}
+	name := id
+	if date := data.Get("date"); date != "" {
+		// the date is sent in one of several formats
+		for _, format := range dayDateFormats {
+			if t, err := time.Parse(format, date); err == nil {
+				date = t.Format(dayDateFormats[0])
+				break
 			}
-			return doc
 		}
-		// not bandwidth limit exceeded (inofficial)
-		if resp.StatusCode == 509 { //|| resp.StatusCode == 500 {
-			sleepTime := time.Duration(i) * httpSleepStep
-			time.Sleep(sleepTime)
-		} else {
-			log.Printf("%s: got status code %d\n", url, resp.StatusCode)
-			return nil
+		name += "_" + date
+	}
+	dir := filepath.Join(p.cfg.FetchDump, strings.TrimSuffix(path.Base(u.Path), ".html"))
+	if err := mkdirAll(dir, p.cfg.OutputMode); err != nil {
+		log.Printf("%s: dump: %s\n", id, err)
+		return
+	}
+	if err := writeFileAtomic(filepath.Join(dir, name+".html"), body, p.cfg.OutputMode); err != nil {
+		log.Printf("%s: dump: %s\n", id, err)
+	}
+}
+
+// listboxSelectors locate the canteen listbox, most specific first, so the
+// listing survives the site renaming either its id or its class
+var listboxSelectors = []string{
+	"select#listboxEinrichtungen.listboxStandorte",
+	"select#listboxEinrichtungen",
+	"select.listboxStandorte",
+}
+
+// findListbox returns the first canteen listbox of doc together with the
+// selector that matched it; the selection is empty if none did
+func findListbox(doc *goquery.Document) (*goquery.Selection, string) {
+	for _, selector := range listboxSelectors {
+		if s := doc.Find(selector); s.Length() > 0 {
+			return s.First(), selector
 		}
 	}
-	log.Printf("aborting after %d retries for POST fetch at %s with %s", httpMaxRetries, url, data)
-	return nil
+	return doc.Find(listboxSelectors[0]), listboxSelectors[0]
+}
+
+// singleCanteenName returns the first non-empty heading of a canteen page
+// without listbox
+func singleCanteenName(doc *goquery.Document) (name string) {
+	doc.Find("h1, h2, h3").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		name = flattenText(s)
+		return name == ""
+	})
+	return
+}
+
+// ListingError is returned by FetchIds; without the listing nothing can be
+// generated, so unlike errors of single canteens it fails the whole run
+type ListingError struct {
+	Err error
+}
+
+func (e *ListingError) Error() string {
+	return "listing: " + e.Err.Error()
 }
 
-func fetchIds() []string {
-	doc := getHttpDoc(urlMeta, url.Values{"resources_id": {defaultID}})
+func (e *ListingError) Unwrap() error {
+	return e.Err
+}
 
-	list := doc.Find("select#listboxEinrichtungen.listboxStandorte option[value]")
-	ids := make([]string, list.Length())
+// FetchIds returns the ids of the canteen listing; the listing has a higher
+// retry budget (ListingRetries) than the per-canteen fetches
+func (p *Parser) FetchIds(ctx context.Context) ([]string, error) {
+	doc, err := p.getHttpDocRetries(ctx, p.cfg.MetaURL, url.Values{"resources_id": {p.cfg.DefaultID}}, p.cfg.ListingRetries)
+	if err != nil {
+		return nil, &ListingError{err}
+	}
 
-	list.Each(func(i int, s *goquery.Selection) {
-		id, _ := s.Attr("value")
-		ids[i] = id
+	var ids []string
+	listbox, selector := findListbox(doc)
+	log.Printf("listing: using `%s`\n", selector)
+	listbox.Find("option").Each(func(i int, s *goquery.Selection) {
+		id := strings.TrimSpace(s.AttrOr("value", ""))
+		if id == "" {
+			log.Printf("listing: skipping option `%s` without value\n", strings.TrimSpace(s.Text()))
+			return
+		}
+		if err := checkId(id); err != nil {
+			log.Printf("listing: skipping option `%s`: %s\n", strings.TrimSpace(s.Text()), err)
+			return
+		}
+		ids = append(ids, id)
 	})
-	return ids
+	if len(ids) == 0 {
+		// an empty listing would move every canteen to the archive
+		return nil, &ListingError{errors.New("no canteens found")}
+	}
+	// duplicates are removed by sortIds
+	return ids, nil
 }
 
-func getMetadata(id string) *Canteen {
-	doc := getHttpDoc(urlMeta, url.Values{"resources_id": {id}})
+var (
+	reMensaToGo   = regexp.MustCompile(`mensa=(\d*)`)
+	reBezirk      = regexp.MustCompile(`\(Bezirk(.*)\)`)
+	reAddressLine = regexp.MustCompile(`\b.*\b`)
+	reLonLat      = regexp.MustCompile(`fromLonLat\(\[ (?P<longitude>-?\d+\.\d+), (?P<latitude>-?\d+\.\d+)`)
+	// the day range only takes the names of weekdays, not just any word
+	// ("Mittag bis …")
+	reOpeningHours = regexp.MustCompile(`\b(?P<dayStart>` + weekdayPattern() + `)\b\.?(?: – (?P<dayEnd>` + weekdayPattern() + `)\b\.?)?.*\n.*\b(?P<hoursStart>\d{1,2}:\d{2}) – (?P<hoursEnd>\d{1,2}:\d{2}) Uhr`)
+)
+
+// Metadata fetches and parses the metadata of a canteen
+func (p *Parser) Metadata(ctx context.Context, id string) (*Canteen, error) {
+	doc, err := p.getHttpDoc(ctx, p.cfg.MetaURL, url.Values{"resources_id": {id}})
+	if err != nil {
+		return nil, err
+	}
+
+	listbox, _ := findListbox(doc)
+	name := strings.TrimSpace(listbox.Find("option[selected]").Text())
 
-	name := strings.TrimSpace(doc.Find("select#listboxEinrichtungen.listboxStandorte option[selected]").Text())
+	// pages of a single canteen come without listbox but name it in the heading
+	if name == "" && listbox.Length() == 0 {
+		if name = singleCanteenName(doc); name != "" {
+			log.Printf("%s: name `%s` determined from heading\n", id, name)
+		}
+	}
 
 	// use direct link instead
 	if name == "" {
-		directLink := doc.Find("div#directlink").Text()
+		directLink := strings.TrimSpace(doc.Find("div#directlink").Text())
 
 		// use iframe from mensatogo instead
 		if directLink == "" {
@@ -96,304 +448,1509 @@ func getMetadata(id string) *Canteen {
 
 			if iframe == "" {
 				//name = strings.TrimSpace(doc.Find("h2").First().Text())
-				log.Printf("%s: unable to determine name\n", id)
+				p.warnf("%s: unable to determine name\n", id)
+			} else if doc2, err := p.getHttpDoc(ctx, iframe, nil); err != nil {
+				if abortsRun(err) {
+					return nil, err
+				}
+				p.warnf("%s: unable to determine name with mensatogo method: %s\n", id, err)
 			} else {
-				doc2 := getHttpDoc(iframe, nil)
-
-				re := regexp.MustCompile(`mensa=(\d*)`)
-				if m := re.FindStringSubmatch(iframe); m == nil {
-					log.Printf("%s: unable to determine name with mensatogo method\n", id)
+				if m := reMensaToGo.FindStringSubmatch(iframe); m == nil {
+					p.warnf("%s: unable to determine name with mensatogo method\n", id)
 				} else {
 					// TODO: does not respect escaped \"
-					re, err := regexp.Compile(`var locations = JSON\.parse\(.*"` + m[1] + `":("[^"]*")`)
+					re, err := regexp.Compile(`var locations = JSON\.parse\(.*"` + regexp.QuoteMeta(m[1]) + `":("[^"]*")`)
 					if err != nil {
-						log.Fatal(err)
+						return nil, fmt.Errorf("mensatogo: %w", err)
 					}
 					m = re.FindStringSubmatch(doc2.Find("script").Text())
 					if m == nil {
-						log.Printf("%s: unable to determine name with mensatogo method\n", id)
+						p.warnf("%s: unable to determine name with mensatogo method\n", id)
 					} else {
 						dec := json.NewDecoder(strings.NewReader(m[1]))
 						if err := dec.Decode(&name); err != nil {
-							log.Fatal(err)
+							return nil, fmt.Errorf("mensatogo: name %s: %w", m[1], err)
 						}
 						name = strings.TrimSpace(name)
 						log.Printf("%s: name `%s` determined with mensatogo method\n", id, name)
 					}
 				}
 			}
+		} else if link, err := resolveUrl(p.cfg.MetaURL, directLink); err != nil {
+			p.warnf("%s: unable to determine name from directlink `%s`: %s\n", id, directLink, err)
 		} else {
-			doc2 := getHttpDoc(directLink, nil)
-			if doc2 != nil {
-				name = doc2.Find("title").Text()
-				name = name[len("studierendenWERK BERLIN - "):]
+			doc2, err := p.getHttpDoc(ctx, link, nil)
+			if err == nil {
+				name = strings.TrimSpace(doc2.Find("title").Text())
+				name = strings.TrimPrefix(name, "studierendenWERK BERLIN - ")
 				log.Printf("%s: name `%s` determined with directlink method\n", id, name)
+			} else if abortsRun(err) {
+				return nil, err
 			} else {
-				log.Printf("%s: unable to determine name", id)
+				p.warnf("%s: unable to determine name: %s\n", id, err)
 			}
 		}
 	}
 
 	address := doc.Find("i.glyphicon.glyphicon-map-marker").Parent().Next().Text()
-	re := regexp.MustCompile(`\(Bezirk.*\)`)
-	address = re.ReplaceAllString(address, "")
-	re = regexp.MustCompile(`\b.*\b`)
-	address = strings.Join(re.FindAllString(address, -1), ", ")
+	var district string
+	if m := reBezirk.FindStringSubmatch(address); m != nil {
+		district = strings.TrimSpace(m[1])
+	}
+	address = reBezirk.ReplaceAllString(address, "")
+	address = strings.Join(reAddressLine.FindAllString(address, -1), ", ")
 
-	phone := strings.TrimSpace(doc.Find("i.glyphicon.glyphicon-earphone").Parent().Next().Text())
+	phone, fax := parsePhone(doc.Find("i.glyphicon.glyphicon-earphone").Parent().Next().Text())
+	if fax != "" {
+		log.Printf("%s: fax `%s` is not part of the feed\n", id, fax)
+	}
 
-	email := doc.Find("i.glyphicon.glyphicon-envelope").Parent().Next().Children().Text()
+	email, err := parseEmail(doc.Find("i.glyphicon.glyphicon-envelope").Parent().Next())
+	if err != nil {
+		p.warnf("%s: %s: %s\n", id, name, err)
+	}
 
-	source := doc.Find("div#directlink").Text()
+	sources := collectSources(doc, p.cfg.MetaURL)
+	var source string
+	if len(sources) > 0 {
+		source = sources[0]
+	}
 
 	var location *Location
 	osm := doc.Find("script")
 	if osm.Length() > 0 {
-		re = regexp.MustCompile(`fromLonLat\(\[ (?P<longitude>-?\d+\.\d+), (?P<latitude>-?\d+\.\d+)`)
-		if m := re.FindStringSubmatch(osm.Text()); m == nil {
-			log.Printf("%s: %s: did not find location coordinates within \"%s\"\n", id, name, osm.Text())
+		if m := reLonLat.FindStringSubmatch(osm.Text()); m == nil {
+			p.warnf("%s: %s: did not find location coordinates within \"%s\"\n", id, name, osm.Text())
 		} else {
 			location = &Location{Longitude: m[1], Latitude: m[2]}
 		}
 	}
 
-	days := []string{"Mo", "Di", "Mi", "Do", "Fr", "Sa", "So"}
 	openingHours := make([]string, 7)
+	// without any parsable entry the hours are unknown, not closed all week
+	var hoursFound bool
 
 	times := doc.Find("i.glyphicon.glyphicon-time").Parent().Parent().Next()
-	re = regexp.MustCompile(`(?P<dayStart>[DFMS][aior])\.(?: – (?P<dayEnd>[DFMS][aior])\.)?.*\n.*(?P<hoursStart>\d{2}:\d{2}) – (?P<hoursEnd>\d{2}:\d{2}) Uhr`)
-	for i := 0; i < len(days); i++ {
-		m := re.FindStringSubmatch(times.Text())
+	for i := 0; i < len(openingHours); i, times = i+1, times.Next() {
+		// the hours during the semester break are no regular hours
+		if reSemesterBreak.MatchString(times.Text()) {
+			break
+		}
+		m := reOpeningHours.FindStringSubmatch(times.Text())
 		if len(m) == 0 {
 			break
 		}
 
-		var dayStart, dayEnd int
-		for j, day := range days {
-			if m[re.SubexpIndex("dayStart")] == day {
-				dayStart = j
-				break
-			}
+		startToken, endToken := m[reOpeningHours.SubexpIndex("dayStart")], m[reOpeningHours.SubexpIndex("dayEnd")]
+		if endToken == "" {
+			endToken = startToken
 		}
-		if m[re.SubexpIndex("dayEnd")] == "" {
-			dayEnd = dayStart
-		} else {
-			for j, day := range days {
-				if m[re.SubexpIndex("dayEnd")] == day {
-					dayEnd = j
-					break
-				}
-			}
+		dayStart, ok := weekdayIndex(startToken)
+		if !ok {
+			p.warnf("%s: %s: skipping opening hours of unknown day `%s`\n", id, name, startToken)
+			continue
+		}
+		dayEnd, ok := weekdayIndex(endToken)
+		if !ok {
+			p.warnf("%s: %s: skipping opening hours of unknown day `%s`\n", id, name, endToken)
+			continue
 		}
 		if dayEnd < dayStart {
-			panic("dayEnd < dayStart")
+			log.Printf("%s: %s: day range %s – %s wraps around the weekend\n", id, name, startToken, endToken)
 		}
 
-		for j := dayStart; j <= dayEnd; j++ {
-			openingHours[j] = strings.Join(m[re.SubexpIndex("hoursStart"):], "-")
+		// a range wraps the week: "Fr. – Mo." is Friday to Monday
+		hours := clockTime(m[reOpeningHours.SubexpIndex("hoursStart")]) + "-" + clockTime(m[reOpeningHours.SubexpIndex("hoursEnd")])
+		for j := dayStart; ; j = (j + 1) % len(openingHours) {
+			openingHours[j] = hours
+			if j == dayEnd {
+				break
+			}
 		}
+		hoursFound = true
+	}
 
-		times = times.Next()
+	banners := bannerText(doc)
+	status := parseStatusBanner(banners)
+	if status != "" {
+		log.Printf("%s: %s: today's status `%s`\n", id, name, status)
+	}
+	closure := parseClosure(banners)
+	if closure != "" {
+		log.Printf("%s: %s: closed long-term `%s`\n", id, name, closure)
 	}
 
-	return &Canteen{
-		Name:         name,
-		Address:      address,
-		City:         "Berlin",
-		Phone:        phone,
-		Email:        email,
-		Location:     location,
-		Availability: "public",
-		Times:        &Times{openingHours: openingHours},
-		Feeds: []Feed{Feed{
+	accessibility := parseAccessibility(doc)
+
+	semesterBreak := parseSemesterBreak(doc)
+	if semesterBreak != nil {
+		log.Printf("%s: %s: semester break `%s`\n", id, name, semesterBreak.Note)
+	}
+
+	transit := parseTransit(doc)
+	if len(transit) > 0 {
+		log.Printf("%s: %s: transit %s\n", id, name, strings.Join(transit, ", "))
+	}
+
+	operator := parseOperator(doc)
+	if operator != "" {
+		log.Printf("%s: %s: operated by %s\n", id, name, operator)
+	}
+
+	seats, err := parseSeats(doc.Find("i.glyphicon.glyphicon-info-sign").Parent().Next().Text())
+	if err != nil {
+		p.warnf("%s: %s: %s\n", id, name, err)
+	} else if seats > 0 {
+		log.Printf("%s: %s: %d seats\n", id, name, seats)
+	}
+
+	var openingTimes *Times
+	if hoursFound {
+		openingTimes = &Times{openingHours: openingHours, typ: p.cfg.TimesType}
+	} else {
+		log.Printf("%s: %s: no opening hours found\n", id, name)
+	}
+
+	// metadata-only output for static catalogs carries no feed references
+	var feeds []Feed
+	if !p.cfg.OmitFeeds {
+		feeds = []Feed{Feed{
 			Name:     "full",
-			Schedule: &FeedSchedule{Hour: "8", Retry: "45 3 1440"},
-			Url:      urlFeedBase + id + "/full.xml",
+			Schedule: p.cfg.FeedSchedules.of("full"),
+			Url:      p.feedUrl(id, fullFile),
 			Source:   source,
-		}},
+		}}
+		if p.cfg.TodayFeed {
+			feeds = append(feeds, Feed{
+				Name:     "today",
+				Schedule: p.cfg.FeedSchedules.of("today"),
+				Url:      p.feedUrl(id, todayFile),
+				Source:   source,
+			})
+		}
 	}
+
+	return &Canteen{
+		Name:          name,
+		Address:       address,
+		City:          "Berlin",
+		District:      district,
+		Phone:         phone,
+		Fax:           fax,
+		Email:         email,
+		Location:      location,
+		Availability:  "public",
+		Times:         openingTimes,
+		TodayStatus:   status,
+		Closure:       closure,
+		Sources:       sources,
+		Accessibility: accessibility,
+		Transit:       transit,
+		Operator:      operator,
+		Seats:         seats,
+		SemesterBreak: semesterBreak,
+		Feeds:         feeds,
+	}, nil
 }
 
-func getDay(id, date string) (d Day) {
-	d.Date = date
-	doc := getHttpDoc(urlMeal, url.Values{"resources_id": {id}, "date": {date}})
+// resolveUrl resolves ref, which may be relative like the directlink of
+// some pages, against the URL of the page it was found on
+func resolveUrl(pageUrl, ref string) (string, error) {
+	base, err := url.Parse(pageUrl)
+	if err != nil {
+		return "", err
+	}
+	u, err := base.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
 
-	categories := doc.Find("div.splGroupWrapper")
-	if categories.Length() == 1 && categories.Find("div").Length() == 0 && strings.TrimSpace(categories.Find("br").Text()) == "Kein Speisenangebot" {
-		return
+// clockTime zero-pads the hour of a "H:MM" time to "HH:MM"
+func clockTime(s string) string {
+	if len(s) == len("H:MM") {
+		return "0" + s
 	}
-	// loop over categories
-	categories.EachWithBreak(func(i int, s *goquery.Selection) bool {
-		// check if no meals are served
-		if i < 1 && s.Find("div").Length() == 0 && strings.TrimSpace(s.Find("br").Text()) == "Kein Speiseangebot" {
-			log.Println("INFO:", id, date, "kein Speiseangebot")
-			return false
-		}
-		c := Category{Name: strings.TrimSpace(s.Find("div.splGroup").Text())}
+	return s
+}
 
-		// loop over meals
-		s.Find("div.splMeal").Each(func(i int, s *goquery.Selection) {
-			name := strings.TrimSpace(s.Find("span.bold").Text())
-			if len(name) == 0 {
-				log.Printf("%s: %s: %s: encoutered an meal without a name tag\n", id, date, c.Name)
-				name = "N. N."
+// collectSources returns the pages a canteen's meals are taken from, the
+// most canonical first: the direct link, then linked live meal plan pages.
+// Relative links are resolved against pageUrl, the URL of doc. OpenMensa only
+// takes one source per feed.
+func collectSources(doc *goquery.Document, pageUrl string) []string {
+	var sources []string
+	add := func(source string) {
+		source = strings.TrimSpace(source)
+		if source == "" {
+			return
+		}
+		source, err := resolveUrl(pageUrl, source)
+		if err != nil || !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+			return
+		}
+		for _, s := range sources {
+			if s == source {
+				return
 			}
-			meal := Meal{Name: name}
+		}
+		sources = append(sources, source)
+	}
 
-			// prices: if only one price tag is present only use it for 'other'
-			prices := strings.TrimSpace(s.Find("div.text-right").Text())
-
-			re := regexp.MustCompile(`\d+,\d{2}`)
-			m := re.FindAllString(prices, -1)
-			switch len(m) {
-			case 0:
-				// regularly the case for slat dressing, so do not log
-				// log.Printf("%s: %s: did not find prices within \"%s\"\n", ids[id], name, prices)
-			case 1:
-				meal.Prices = []Price{Price{
-					Price: strings.Replace(m[0], ",", ".", 1),
-					Role:  "other",
-				}}
-			case 3:
-				meal.Prices = make([]Price, len(m))
-				pricesRoles := [...]string{"student", "employee", "other"}
-				for j, price := range m {
-					meal.Prices[j] = Price{
-						Price: strings.Replace(price, ",", ".", 1),
-						Role:  pricesRoles[j],
-					}
-				}
-			default:
-				log.Printf("%s: %s: did find %s prices but expected 0, 1 or 3 within \"%s\"\n", id, name, len(m), prices)
-			}
+	add(doc.Find("div#directlink").Text())
+	doc.Find("a[href*='speiseplan']").Each(func(i int, s *goquery.Selection) {
+		add(s.AttrOr("href", ""))
+	})
+	return sources
+}
 
-			// notes from icons
-			notesImg := map[string]Note{
-				"ampel_gruen_70x65.png": "grün (Ampel)",
-				"ampel_gelb_70x65.png":  "gelb (Ampel)",
-				"ampel_rot_70x65.png":   "rot (Ampel)",
-				"15.png":                "vegan",
-				"43.png":                "Klimaessen",
-				"1.png":                 "vegetarisch",
-				"18.png":                "bio",
-				"38.png":                "MSC",
-			}
-			s.Find("img.splIcon").Each(func(i int, s *goquery.Selection) {
-				imgUrl := s.AttrOr("src", "")
-				for suffix, note := range notesImg {
-					if strings.HasSuffix(imgUrl, suffix) {
-						meal.Notes = append(meal.Notes, note)
-						break
-					}
-				}
-			})
+var reAccessibility = regexp.MustCompile(`(?i)(?:(?:nicht|eingeschränkt|teilweise|bedingt)\s+)?(?:barrierefrei|rollstuhlgerecht|rollstuhlzugänglich)`)
 
-			// notes from text
-			s.Find("div.kennz td").Not("td.text-right").Each(func(i int, s *goquery.Selection) {
-				meal.Notes = append(meal.Notes, Note(s.Text()))
-			})
+// parseAccessibility returns the barrier-free access statement of a canteen
+// page like "barrierefrei" or "eingeschränkt barrierefrei", taken from the
+// text or from the alt/title of an icon; empty if the page has none
+func parseAccessibility(doc *goquery.Document) string {
+	var found string
+	doc.Find("img[alt], img[title], i[title]").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		found = reAccessibility.FindString(s.AttrOr("alt", "") + " " + s.AttrOr("title", ""))
+		return found == ""
+	})
+	if found == "" {
+		found = reAccessibility.FindString(doc.Find("body").Text())
+	}
+	return strings.ToLower(strings.Join(strings.Fields(found), " "))
+}
 
-			c.Meals = append(c.Meals, meal)
-		})
+var (
+	reTransit      = regexp.MustCompile(`(?i)(?:Verkehrsanbindung|ÖPNV|Anfahrt|Haltestellen?)\s*:\s*(.+)`)
+	reTransitSplit = regexp.MustCompile(`\s*(?:[,;]|\s/\s|\sund\s)\s*`)
+)
 
-		d.Categories = append(d.Categories, c)
-		return true
+// parseTransit returns the nearby stops like "U2 Ernst-Reuter-Platz" or
+// "Bus 245" listed after a label like "Verkehrsanbindung:", in page order
+func parseTransit(doc *goquery.Document) []string {
+	var stops []string
+	seen := make(map[string]bool)
+	doc.Find("p, li, dd, td, span").Each(func(i int, s *goquery.Selection) {
+		m := reTransit.FindStringSubmatch(strings.Join(strings.Fields(s.Text()), " "))
+		if m == nil {
+			return
+		}
+		for _, stop := range reTransitSplit.Split(m[1], -1) {
+			stop = strings.TrimRight(stop, ".")
+			if stop != "" && !seen[stop] {
+				seen[stop] = true
+				stops = append(stops, stop)
+			}
+		}
 	})
-	return
+	return stops
 }
 
-func getMeals(id string, daysBefore, daysAfter int) (c *Canteen) {
-	c = &Canteen{}
-	now := time.Now()
+var (
+	reOperator    = regexp.MustCompile(`(?i)(?:Betreiber(?:in)?|betrieben (?:von|durch))\s*:?\s*([^:\s].*)`)
+	reOwnOperator = regexp.MustCompile(`(?i)^studierendenwerk\b`)
+)
 
-	for i := daysBefore; i <= daysAfter; i++ {
-		date := now.AddDate(0, 0, i).Format("2006-01-02")
-		c.Days = append(c.Days, getDay(id, date))
+// parseOperator returns the external operator named after a label like
+// "Betreiber:" in the innermost block mentioning it; canteens run by the
+// studierendenWERK itself have none
+func parseOperator(doc *goquery.Document) (operator string) {
+	shortest := -1
+	doc.Find("p, li, dd, td, span, div").Each(func(i int, s *goquery.Selection) {
+		text := strings.Join(strings.Fields(s.Text()), " ")
+		m := reOperator.FindStringSubmatch(text)
+		if m == nil || shortest >= 0 && len(text) >= shortest {
+			return
+		}
+		shortest = len(text)
+		operator = strings.TrimRight(m[1], ".;,")
+	})
+	if reOwnOperator.MatchString(operator) {
+		return ""
 	}
-
 	return
 }
 
-func genIndex(idsCur, idsArchived []string) error {
-	log.Println("generate", indexFile, "(index)")
-
-	file, err := os.Create(indexFile)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
+var (
+	reSeats = regexp.MustCompile(`(?i)sitzpl(?:ä|ae)tze`)
+	// the count is right next to the label and no part of another number
+	// like the "30" of "11:30"
+	reSeatsCount = regexp.MustCompile(`(?i)(?:^|[^\d.:,])(\d{1,3}(?:\.\d{3})+|\d+)\s*sitzpl(?:ä|ae)tze|sitzpl(?:ä|ae)tze\s*:?\s*(?:ca\.\s*)?(\d{1,3}(?:\.\d{3})+|\d+)`)
+)
 
-	_, err = fmt.Fprintf(file, "{\n")
-	if err != nil {
-		return err
-	}
-	for _, id := range idsCur {
-		jsonId, _ := json.Marshal(id)
-		jsonUrl, _ := json.Marshal(urlFeedBase + id + "/metadata.xml")
-		_, err = fmt.Fprintf(file, "    %s: %s,\n", jsonId, jsonUrl)
-		if err != nil {
-			return err
+// parseSeats returns the seating capacity stated like "350 Sitzplätze" or
+// "Sitzplätze: ca. 1.200" in text, the info block of a canteen page; 0 if
+// none is stated, an error if the capacity is mentioned without a number
+func parseSeats(text string) (int, error) {
+	text = strings.Join(strings.Fields(text), " ")
+	m := reSeatsCount.FindStringSubmatch(text)
+	if m == nil {
+		if loc := reSeats.FindStringIndex(text); loc != nil {
+			return 0, fmt.Errorf("no seat count in `%s`", text[loc[0]:loc[1]])
 		}
+		return 0, nil
 	}
-	file.Seek(-2, os.SEEK_CUR)
-	if err != nil {
-		return err
+	count := m[1]
+	if count == "" {
+		count = m[2]
 	}
-	_, err = fmt.Fprintf(file, "\n}\n")
-	return err
+	return strconv.Atoi(strings.ReplaceAll(count, ".", ""))
 }
 
-func sortStringInts(list []string) {
-	sort.Slice(list, func(i, j int) bool {
-		a, _ := strconv.Atoi(list[i])
-		b, _ := strconv.Atoi(list[j])
-		return a < b
-	})
+var reEmailAt = regexp.MustCompile(`\s*[(\[{]\s*(?:at|ät)\s*[)\]}]\s*`)
 
+// parseEmail returns the address of the email block s, preferring a mailto
+// link over the visible text, which may be obfuscated like "mensa (at) x.de"
+func parseEmail(s *goquery.Selection) (string, error) {
+	email := strings.TrimSpace(s.Find("a[href^='mailto:']").First().AttrOr("href", ""))
+	if email != "" {
+		email = strings.TrimPrefix(email, "mailto:")
+		if i := strings.IndexByte(email, '?'); i >= 0 {
+			email = email[:i]
+		}
+		if unescaped, err := url.PathUnescape(email); err == nil {
+			email = unescaped
+		}
+	} else {
+		email = reEmailAt.ReplaceAllString(strings.Join(strings.Fields(s.Text()), " "), "@")
+	}
+	if email == "" {
+		return "", nil
+	}
+	if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+		return "", fmt.Errorf("invalid email address `%s`", email)
+	}
+	return email, nil
 }
 
-func saveIds(ids *[]string, filename string) error {
-	log.Println("generate", filename)
-	file, err := os.Create(filename)
-	if err != nil {
-		return err
+var (
+	reSemesterBreak = regexp.MustCompile(`(?i)semesterferien|vorlesungsfreie[nr]? zeit`)
+	reFullDate      = regexp.MustCompile(`\b(\d{1,2})\.(\d{1,2})\.(\d{4})\b`)
+)
+
+// parseSemesterBreak returns the note about differing hours during the
+// semester break, like "Öffnungszeiten abweichend in den Semesterferien
+// (15.07.2024 – 14.10.2024): Mo. – Fr. 11:00 – 14:00 Uhr", or nil
+func parseSemesterBreak(doc *goquery.Document) *SemesterBreak {
+	var note string
+	doc.Find("div, p, li, dd, td").Each(func(i int, s *goquery.Selection) {
+		text := strings.Join(strings.Fields(s.Text()), " ")
+		// the innermost block mentioning the break holds the note
+		if reSemesterBreak.MatchString(text) && (note == "" || len(text) < len(note)) {
+			note = text
+		}
+	})
+	if note == "" {
+		return nil
 	}
-	defer file.Close()
 
-	w := bufio.NewWriter(file)
-	for _, id := range *ids {
-		if strings.ContainsRune(id, '\n') {
-			return errors.New("Id contains newline")
+	b := &SemesterBreak{Note: note}
+	var dates []string
+	for _, m := range reFullDate.FindAllStringSubmatch(note, 2) {
+		t, err := time.Parse("2.1.2006", m[1]+"."+m[2]+"."+m[3])
+		if err == nil {
+			dates = append(dates, t.Format("2006-01-02"))
 		}
-		fmt.Fprintln(w, id)
 	}
-	return w.Flush()
+	if len(dates) == 2 {
+		b.From, b.To = dates[0], dates[1]
+	}
+	return b
 }
 
-func readLines(path string) ([]string, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
+// bannerSelector selects the status banners of a canteen page
+const bannerSelector = ".alert, .banner, [role='alert']"
 
-	var lines []string
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		lines = append(lines, scanner.Text())
-	}
-	return lines, scanner.Err()
+// bannerText returns the text of the status banners of a canteen page, one
+// per line; other text like a news item about another canteen tells nothing
+func bannerText(doc *goquery.Document) string {
+	var banners []string
+	doc.Find(bannerSelector).Each(func(i int, s *goquery.Selection) {
+		banners = append(banners, s.Text())
+	})
+	return strings.Join(banners, "\n")
 }
 
-func loadIds(filename string) ([]string, error) {
-	if _, err := os.Stat(filename); errors.Is(err, os.ErrNotExist) {
-		return nil, nil
-	}
+var reStatusBanner = regexp.MustCompile(`(?i)heute\s+(?:geöffnet(?:\s+(?:bis|von)\s+\d{1,2}[:.]\d{2}(?:\s*(?:–|-|bis)\s*\d{1,2}[:.]\d{2})?(?:\s*Uhr)?)?|geschlossen)`)
 
-	log.Println("restore IDs from", filename)
-	file, err := os.Open(filename)
-	if err != nil {
-		return nil, err
+// parseStatusBanner returns the live banner like "heute geöffnet bis 15:00"
+// or "heute geschlossen" found in text, with whitespace normalized
+func parseStatusBanner(text string) string {
+	return strings.Join(strings.Fields(reStatusBanner.FindString(text)), " ")
+}
+
+var reClosure = regexp.MustCompile(`(?i)(?:bis auf weiteres|dauerhaft|vorübergehend|langfristig|wegen (?:umbau|sanierung|renovierung))\s+geschlossen|geschlossen\s+bis\s+(?:auf\s+weiteres|(?:zum\s+)?\d{1,2}\.\s*\d{1,2}\.(?:\d{4}|\d{2})?)`)
+
+// parseClosure returns a banner like "bis auf Weiteres geschlossen" or
+// "geschlossen bis 31.03.2027" found in text, with whitespace normalized;
+// unlike "heute geschlossen" it means the canteen is closed long-term
+func parseClosure(text string) string {
+	return strings.Join(strings.Fields(reClosure.FindString(text)), " ")
+}
+
+// flattenText returns the text of s with line breaks (including <br>) and
+// runs of whitespace collapsed into single spaces
+func flattenText(s *goquery.Selection) string {
+	s.Find("br").ReplaceWithHtml("\n")
+	return strings.Join(strings.Fields(s.Text()), " ")
+}
+
+var (
+	rePhoneLabel = regexp.MustCompile(`(?i)^tel(efon)?\.?:?\s*`)
+	reFaxLabel   = regexp.MustCompile(`(?i)(tele)?fax\.?:?\s*`)
+)
+
+// numberSeparators are trimmed from the numbers of a contact line like
+// "030 123 / Fax 030 456"
+const numberSeparators = " \t\r/,;|"
+
+// parsePhone separates the first phone number from a fax number within the
+// (possibly multi-line) contact text; a line is split at its fax label, the
+// number before it being the phone and the one after it the fax
+func parsePhone(text string) (phone, fax string) {
+	for _, line := range strings.Split(text, "\n") {
+		number := line
+		if loc := reFaxLabel.FindStringIndex(line); loc != nil {
+			number = line[:loc[0]]
+			if fax == "" {
+				fax = strings.Trim(line[loc[1]:], numberSeparators)
+			}
+		}
+		number = strings.Trim(rePhoneLabel.ReplaceAllString(strings.TrimSpace(number), ""), numberSeparators)
+		if phone == "" {
+			phone = number
+		}
+	}
+	return
+}
+
+var (
+	// rePrice matches a German price like "3,45", "1.234,56", "3,45 €" or
+	// "€ 3,45"
+	rePrice     = regexp.MustCompile(`(?:€\s*)?(\d{1,3}(?:\.\d{3})+,\d{2}|\d+,\d{2})(?:\s*€)?`)
+	reFeedPrice = regexp.MustCompile(`^\d+\.\d{2}$`)
+)
+
+// parsePrices returns all prices within text in feed format ("3.45"); euro
+// signs and (non-breaking) spaces around the numbers are ignored
+func parsePrices(text string) []string {
+	text = strings.ReplaceAll(text, "\u00a0", " ")
+
+	var prices []string
+	for _, m := range rePrice.FindAllStringSubmatch(text, -1) {
+		// rePrice only matches what parsePrice accepts
+		price, ok := parsePrice(m[1])
+		if !ok {
+			continue
+		}
+		prices = append(prices, price)
+	}
+	return prices
+}
+
+// parsePrice converts a German price ("3,45", "1.234,56") to feed format
+// ("3.45", "1234.56")
+func parsePrice(s string) (string, bool) {
+	price := strings.Replace(strings.ReplaceAll(s, ".", ""), ",", ".", 1)
+	return price, reFeedPrice.MatchString(price)
+}
+
+// dietNotes maps the lower-cased diet labels to their note, ordered from the
+// least to the most specific
+var dietNotes = []struct {
+	label string
+	note  Note
+}{
+	{"vegetarisch", "vegetarisch"},
+	{"vegan", "vegan"},
+}
+
+// dietRank returns the position of n in dietNotes or -1
+func dietRank(n Note) int {
+	label := strings.ToLower(strings.TrimSpace(string(n)))
+	for i, diet := range dietNotes {
+		if label == diet.label {
+			return i
+		}
+	}
+	return -1
+}
+
+// ampelNotes are the traffic-light ratings, from the least to the most severe
+var ampelNotes = []Note{"grün (Ampel)", "gelb (Ampel)", "rot (Ampel)"}
+
+// ampelRank returns the index of n within ampelNotes, or -1
+func ampelRank(n Note) int {
+	for i, a := range ampelNotes {
+		if n == a {
+			return i
+		}
+	}
+	return -1
+}
+
+// ampelCount returns the number of distinct ampel ratings among notes
+func ampelCount(notes []Note) int {
+	seen := make(map[Note]bool)
+	for _, n := range notes {
+		if ampelRank(n) >= 0 {
+			seen[n] = true
+		}
+	}
+	return len(seen)
+}
+
+// singleAmpel reduces the ampel ratings among notes to the most severe one,
+// at the position of the first
+func singleAmpel(notes []Note) []Note {
+	worst := -1
+	for _, n := range notes {
+		if r := ampelRank(n); r > worst {
+			worst = r
+		}
+	}
+
+	var result []Note
+	kept := false
+	for _, n := range notes {
+		if ampelRank(n) >= 0 {
+			if kept {
+				continue
+			}
+			n, kept = ampelNotes[worst], true
+		}
+		result = append(result, n)
+	}
+	return result
+}
+
+// reconcileNotes merges the notes derived from icons and from text labels:
+// duplicates are dropped and of all diet notes only the most specific one is
+// kept (at the position of the first diet note). conflict reports whether
+// both sources state a diet but different ones.
+func reconcileNotes(iconNotes, textNotes []Note) (notes []Note, conflict bool) {
+	iconDiet, textDiet := -1, -1
+	for _, n := range iconNotes {
+		if r := dietRank(n); r > iconDiet {
+			iconDiet = r
+		}
+	}
+	for _, n := range textNotes {
+		if r := dietRank(n); r > textDiet {
+			textDiet = r
+		}
+	}
+	diet := iconDiet
+	if textDiet > diet {
+		diet = textDiet
+	}
+	conflict = iconDiet >= 0 && textDiet >= 0 && iconDiet != textDiet
+
+	seen := make(map[Note]bool)
+	for _, n := range append(append([]Note(nil), iconNotes...), textNotes...) {
+		if dietRank(n) >= 0 {
+			n = dietNotes[diet].note
+		}
+		if !seen[n] {
+			seen[n] = true
+			notes = append(notes, n)
+		}
+	}
+	return
+}
+
+// textMarkers map free-text markers, found in plain notes or in the meal
+// name, to the distinct note consumers can rely on
+var textMarkers = []struct {
+	re   *regexp.Regexp
+	note Note
+}{
+	{regexp.MustCompile(`(?i)vorbestell`), "Vorbestellung erforderlich"},
+}
+
+// markerNotes replaces the notes matching a text marker with the marker's
+// note, adding it as well if the marker is part of the meal name
+func markerNotes(name string, notes []Note) []Note {
+	var result []Note
+	seen := make(map[Note]bool)
+	add := func(n Note) {
+		if !seen[n] {
+			seen[n] = true
+			result = append(result, n)
+		}
+	}
+	for _, n := range notes {
+		replaced := false
+		for _, marker := range textMarkers {
+			if marker.re.MatchString(string(n)) {
+				add(marker.note)
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			add(n)
+		}
+	}
+	for _, marker := range textMarkers {
+		if marker.re.MatchString(name) {
+			add(marker.note)
+		}
+	}
+	return result
+}
+
+// stripNotes removes the notes listed in ignore from notes
+func stripNotes(notes []Note, ignore []string) []Note {
+	if len(ignore) == 0 {
+		return notes
+	}
+	kept := notes[:0]
+	for _, n := range notes {
+		ignored := false
+		for _, s := range ignore {
+			if strings.TrimSpace(string(n)) == s {
+				ignored = true
+				break
+			}
+		}
+		if !ignored {
+			kept = append(kept, n)
+		}
+	}
+	return kept
+}
+
+var reStand = regexp.MustCompile(`Stand:?\s*(\d{1,2})\.(\d{1,2})\.(\d{4})`)
+
+// parseStand returns the "Stand: TT.MM.JJJJ" last-updated date within text
+// as ISO date, or "" if there is none
+func parseStand(text string) string {
+	m := reStand.FindStringSubmatch(text)
+	if m == nil {
+		return ""
+	}
+	t, err := time.Parse("2.1.2006", m[1]+"."+m[2]+"."+m[3])
+	if err != nil {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
+
+// dayDateFormats are the formats of the date parameter tried for the meal
+// page, the first one is what the xhr endpoint normally expects
+var dayDateFormats = []string{"2006-01-02", "02.01.2006"}
+
+// reConsentWall matches the text of cookie consent pages
+var reConsentWall = regexp.MustCompile(`(?i)cookie[- ]?(?:consent|einstellungen|hinweis|richtlinie)|einwilligung|alle cookies akzeptieren|accept all cookies`)
+
+// siteSelector matches what every page of the site has, meal pages their
+// category blocks and the other pages the canteen listbox
+var siteSelector = "div.splGroupWrapper, " + strings.Join(listboxSelectors, ", ")
+
+// consentWall reports whether doc is a cookie consent page in front of the
+// site rather than one of its pages
+func consentWall(doc *goquery.Document) bool {
+	return doc.Find(siteSelector).Length() == 0 && reConsentWall.MatchString(doc.Text())
+}
+
+var (
+	// reClosedMarker matches the notices of days without meals
+	reClosedMarker = regexp.MustCompile(`(?i)kein\s+speisen?angebot`)
+
+	// errUnrecognizedEmpty is returned by Day with -strict-closed-detection
+	// for pages without meals that lack a closed notice
+	errUnrecognizedEmpty = errors.New("no meals but no closed notice either, page format changed?")
+)
+
+// Day fetches the meals of date (YYYY-MM-DD); if a page comes back without
+// any category block the other date formats are tried
+func (p *Parser) Day(ctx context.Context, id, date string) (Day, error) {
+	doc, _, err := p.dayDoc(ctx, id, date)
+	if err != nil {
+		return Day{Date: date}, err
+	}
+	return p.dayOf(id, date, doc)
+}
+
+// dayDoc fetches the page of Day, reporting whether all of its requests were
+// answered 304 Not Modified
+func (p *Parser) dayDoc(ctx context.Context, id, date string) (doc *goquery.Document, notModified bool, err error) {
+	sp := p.tracer.start("day", "id", id, "date", date)
+	defer func() { sp.end(err) }()
+
+	t, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return nil, false, err
+	}
+
+	first := 0
+	if k, ok := p.dayFormats.Load(id); ok {
+		first = k.(int)
+	}
+	notModified = true
+	for k := first; k < len(dayDateFormats); k++ {
+		var cached bool
+		doc, cached, err = p.fetchDoc(ctx, p.cfg.MealURL, url.Values{"resources_id": {id}, "date": {t.Format(dayDateFormats[k])}}, p.cfg.MaxRetries)
+		if err != nil {
+			return nil, false, err
+		}
+		notModified = notModified && cached
+		if doc.Find("div.splGroupWrapper").Length() > 0 {
+			if k != first {
+				log.Printf("%s: %s: meal page only answers to date format %s\n", id, date, dayDateFormats[k])
+				p.dayFormats.Store(id, k)
+			}
+			break
+		}
+	}
+	return doc, notModified, nil
+}
+
+// dayOf returns the Day of the page doc fetched for date
+func (p *Parser) dayOf(id, date string, doc *goquery.Document) (Day, error) {
+	if doc.Find(dayHeaderSelector).Length() > 0 {
+		// a multi-day view, of which only the section of date is wanted
+		for _, d := range p.parseDays(id, doc) {
+			if d.Date == date {
+				return d, nil
+			}
+		}
+		return Day{Date: date}, fmt.Errorf("%s: multi-day page lacks the day", date)
+	}
+	d := p.parseDay(id, date, doc)
+	if p.cfg.StrictClosedDetection && d.closed() && !reClosedMarker.MatchString(doc.Text()) {
+		return d, errUnrecognizedEmpty
+	}
+	return d, nil
+}
+
+// dayHeaderSelector matches the day headers of a multi-day view such as the
+// weekly one, each followed by the category blocks of its day
+const dayHeaderSelector = "div.splDayHeader"
+
+// parseDays parses a multi-day view into its days, dated by their headers
+// resolved against the anchor of the run; sections whose header is no date
+// are skipped
+func (p *Parser) parseDays(id string, doc *goquery.Document) []Day {
+	updated := parseStand(doc.Text())
+	var days []Day
+	doc.Find(dayHeaderSelector).Each(func(_ int, header *goquery.Selection) {
+		label := strings.TrimSpace(header.Text())
+		date, ok := resolveDayLabel(label, p.anchor)
+		if !ok {
+			p.warnf("%s: unrecognized day header `%s`\n", id, label)
+			return
+		}
+		var section strings.Builder
+		header.NextUntil(dayHeaderSelector).Each(func(_ int, s *goquery.Selection) {
+			html, _ := goquery.OuterHtml(s)
+			section.WriteString(html)
+		})
+		sub, err := goquery.NewDocumentFromReader(strings.NewReader(section.String()))
+		if err != nil {
+			p.warnf("%s: %s: %s\n", id, date, err)
+			return
+		}
+		d := p.parseDay(id, date, sub)
+		if d.Updated == "" {
+			d.Updated = updated
+		}
+		days = append(days, d)
+	})
+	return days
+}
+
+// priceLabelRoles maps the lowercase price column labels to roles
+var priceLabelRoles = map[string]string{
+	"studierende":  "student",
+	"studenten":    "student",
+	"bedienstete":  "employee",
+	"beschäftigte": "employee",
+	"mitarbeiter":  "employee",
+	"gäste":        "other",
+	"schüler":      "pupil",
+}
+
+// pricesRoles are the roles of the three prices of a meal, in page order
+var pricesRoles = [...]string{"student", "employee", "other"}
+
+// roleTable assigns roles to the prices of a meal, by column label or else
+// by position
+type roleTable struct {
+	labels   map[string]string // lowercase label -> role
+	reLabel  *regexp.Regexp
+	reInline *regexp.Regexp   // a label directly followed by its price
+	counts   map[int][]string // roles of unlabeled prices by their number
+}
+
+// newRoleTable returns the default roles overridden by counts and then by
+// custom, whose keys are column labels, "1" to "3" for the positions of three
+// prices or "single" for a lone price
+func newRoleTable(custom map[string]string, counts map[int][]string) *roleTable {
+	t := &roleTable{
+		labels: make(map[string]string),
+		counts: map[int][]string{1: {"other"}, 3: append([]string(nil), pricesRoles[:]...)},
+	}
+	for label, role := range priceLabelRoles {
+		t.labels[label] = role
+	}
+	for n, roles := range counts {
+		t.counts[n] = append([]string(nil), roles...)
+	}
+	for key, role := range custom {
+		switch key {
+		case "single":
+			t.counts[1] = []string{role}
+		case "1", "2", "3":
+			t.counts[3][key[0]-'1'] = role
+		default:
+			t.labels[strings.ToLower(key)] = role
+		}
+	}
+
+	// longest labels first so that no label shadows a longer one
+	labels := make([]string, 0, len(t.labels))
+	for label := range t.labels {
+		labels = append(labels, regexp.QuoteMeta(label))
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		if len(labels[i]) != len(labels[j]) {
+			return len(labels[i]) > len(labels[j])
+		}
+		return labels[i] < labels[j]
+	})
+	t.reLabel = regexp.MustCompile(`(?i)(` + strings.Join(labels, "|") + `)`)
+	t.reInline = regexp.MustCompile(`(?i)(` + strings.Join(labels, "|") + `)\s*:?\s*(?:€\s*)?(\d{1,3}(?:\.\d{3})+,\d{2}|\d+,\d{2})`)
+	return t
+}
+
+// expected lists the numbers of unlabeled prices that have roles, like "0, 1
+// or 3"
+func (t *roleTable) expected() string {
+	ns := []int{0}
+	for n := range t.counts {
+		ns = append(ns, n)
+	}
+	sort.Ints(ns)
+	var b strings.Builder
+	for i, n := range ns {
+		switch {
+		case i == 0:
+		case i == len(ns)-1:
+			b.WriteString(" or ")
+		default:
+			b.WriteString(", ")
+		}
+		b.WriteString(strconv.Itoa(n))
+	}
+	return b.String()
+}
+
+// labeled returns the roles of n prices named by the column labels in text,
+// in order, or nil unless text labels exactly n prices
+func (t *roleTable) labeled(text string, n int) []string {
+	labels := t.reLabel.FindAllString(text, -1)
+	if n == 0 || len(labels) != n {
+		return nil
+	}
+	roles := make([]string, n)
+	for i, label := range labels {
+		roles[i] = t.labels[strings.ToLower(label)]
+	}
+	return roles
+}
+
+// inline returns the prices given inline in text as "Studierende 2,50 |
+// Bedienstete 3,80 | Gäste 4,90", or nil if there are none
+func (t *roleTable) inline(text string) []Price {
+	var prices []Price
+	for _, m := range t.reInline.FindAllStringSubmatch(strings.ReplaceAll(text, "\u00a0", " "), -1) {
+		price, ok := parsePrice(m[2])
+		if !ok {
+			continue
+		}
+		prices = append(prices, Price{Price: price, Role: t.labels[strings.ToLower(m[1])]})
+	}
+	return prices
+}
+
+// notesImg maps icon file names to the notes they stand for
+var notesImg = map[string]Note{
+	"ampel_gruen_70x65.png": "grün (Ampel)",
+	"ampel_gelb_70x65.png":  "gelb (Ampel)",
+	"ampel_rot_70x65.png":   "rot (Ampel)",
+	"15.png":                "vegan",
+	"43.png":                "Klimaessen",
+	"1.png":                 "vegetarisch",
+	"18.png":                "bio",
+	"38.png":                "MSC",
+}
+
+// iconName returns the file name of the icon at src, without the query
+func iconName(src string) string {
+	if u, err := url.Parse(src); err == nil {
+		src = u.Path
+	}
+	return path.Base(src)
+}
+
+// mealImage returns the absolute URL of the dish photo of meal s, if any;
+// lazy-loaded images carry the URL in a data attribute and a placeholder in
+// src
+func mealImage(s *goquery.Selection, pageUrl string) string {
+	var image string
+	s.Find("img").Not("img.splIcon").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		for _, attr := range []string{"data-src", "data-lazy-src", "data-original", "src"} {
+			src := strings.TrimSpace(s.AttrOr(attr, ""))
+			if src == "" || strings.HasPrefix(src, "data:") {
+				continue
+			}
+			base, err := url.Parse(pageUrl)
+			if err != nil {
+				return false
+			}
+			ref, err := url.Parse(src)
+			if err != nil {
+				continue
+			}
+			image = base.ResolveReference(ref).String()
+			return false
+		}
+		return true
+	})
+	return image
+}
+
+// footnoteMarkers selects the allergen and additive markers within meal names
+const footnoteMarkers = "sup, .fussnote, .footnote"
+
+// mealName returns the name of a meal from its name tag without the
+// footnote markers, which are returned separately, one per comma-separated
+// marker ("Schnitzel<sup>1,a</sup>" is "Schnitzel" with "1" and "a")
+func mealName(s *goquery.Selection) (name string, footnotes []Note) {
+	s = s.Clone()
+	s.Find(footnoteMarkers).Each(func(i int, s *goquery.Selection) {
+		for _, marker := range strings.Split(s.Text(), ",") {
+			n := Note(strings.Trim(marker, " ()\u00a0"))
+			if n != "" && !hasNote(footnotes, n) {
+				footnotes = append(footnotes, n)
+			}
+		}
+	}).Remove()
+	return flattenText(s), footnotes
+}
+
+// struckPrices selects old prices shown crossed out next to the current ones
+const struckPrices = "del, s, strike, .old-price, .price-old, .strike, [style*='line-through']"
+
+// parseDay extracts the meals of a canteen's day page; id and date are used
+// for the result and logging only
+func (p *Parser) parseDay(id, date string, doc *goquery.Document) (d Day) {
+	d.Date = date
+	d.Updated = parseStand(doc.Text())
+
+	categories := doc.Find("div.splGroupWrapper")
+	if categories.Length() == 1 && categories.Find("div").Length() == 0 && strings.TrimSpace(categories.Find("br").Text()) == "Kein Speisenangebot" {
+		return
+	}
+	// loop over categories
+	categories.EachWithBreak(func(i int, s *goquery.Selection) bool {
+		// check if no meals are served
+		if i < 1 && s.Find("div").Length() == 0 && strings.TrimSpace(s.Find("br").Text()) == "Kein Speiseangebot" {
+			log.Println("INFO:", id, date, "kein Speiseangebot")
+			return false
+		}
+		c := Category{Name: strings.TrimSpace(s.Find("div.splGroup").Text())}
+
+		// column headers of the category, if any, name the price roles
+		header := s.Clone()
+		header.Find("div.splMeal").Remove()
+		headerText := header.Text()
+
+		// loop over meals
+		s.Find("div.splMeal").Each(func(i int, s *goquery.Selection) {
+			name, footnotes := mealName(s.Find("span.bold"))
+			if len(name) == 0 {
+				p.warnf("%s: %s: %s: encountered a meal without a name tag\n", id, date, c.Name)
+				name = "N. N."
+			}
+			meal := Meal{Name: name, Image: mealImage(s, p.cfg.MealURL)}
+
+			// prices: if only one price tag is present only use it for 'other'
+			// struck-through old prices are no prices of the meal
+			priceCell := s.Find("div.text-right").Clone()
+			priceCell.Find(struckPrices).Remove()
+			prices := strings.TrimSpace(priceCell.Text())
+
+			// items offered in small and large portions become one meal per
+			// portion, named "… (klein)" and "… (groß)"
+			portions := splitPortions(prices)
+			if portions == nil {
+				meal.Prices = p.mealPrices(id, name, prices, headerText)
+			}
+			// some layouts give role and price inline outside the price cell
+			if portions == nil && meal.Prices == nil {
+				rest := s.Clone()
+				rest.Find("span.bold, div.kennz, div.text-right").Remove()
+				meal.Prices = p.roles.inline(rest.Text())
+			}
+
+			// notes from icons
+			var iconNotes, textNotes []Note
+			s.Find("img.splIcon").Each(func(i int, s *goquery.Selection) {
+				imgUrl := s.AttrOr("src", "")
+				if note, ok := notesImg[iconName(imgUrl)]; ok {
+					iconNotes = append(iconNotes, note)
+					p.icons.see(imgUrl, id, date, note)
+					return
+				}
+				p.icons.see(imgUrl, id, date, "")
+				p.warnf("%s: %s: %s: unknown icon %s\n", id, date, name, imgUrl)
+			})
+
+			// notes from text
+			s.Find("div.kennz td").Not("td.text-right").Each(func(i int, s *goquery.Selection) {
+				textNotes = append(textNotes, Note(s.Text()))
+			})
+
+			if ampel := ampelCount(iconNotes); ampel > 1 {
+				p.warnf("%s: %s: %s: %d different ampel icons, keeping the most severe\n", id, date, name, ampel)
+				iconNotes = singleAmpel(iconNotes)
+			}
+
+			var conflict bool
+			meal.Notes, conflict = reconcileNotes(iconNotes, textNotes)
+			if conflict {
+				p.warnf("%s: %s: %s: diet icons %v and labels %v disagree\n", id, date, name, iconNotes, textNotes)
+			}
+			for _, n := range footnotes {
+				if !hasNote(meal.Notes, n) {
+					meal.Notes = append(meal.Notes, n)
+				}
+			}
+			meal.Notes = markerNotes(name, meal.Notes)
+			meal.Notes = stripNotes(meal.Notes, p.cfg.IgnoreNotes)
+			if p.cfg.ImageNotes && meal.Image != "" {
+				meal.Notes = append(meal.Notes, Note(meal.Image))
+			}
+
+			if portions == nil {
+				c.Meals = append(c.Meals, meal)
+				return
+			}
+			for _, portion := range portions {
+				variant := meal
+				variant.Notes = append([]Note(nil), meal.Notes...)
+				variant.Name = fmt.Sprintf("%s (%s)", name, portion.label)
+				variant.Prices = p.mealPrices(id, variant.Name, portion.prices, headerText)
+				c.Meals = append(c.Meals, variant)
+			}
+		})
+
+		d.Categories = append(d.Categories, c)
+		return true
+	})
+	return
+}
+
+// mealPrices assigns roles to the prices found in the price cell text of
+// meal name, by labels in the text or the category header if present
+func (p *Parser) mealPrices(id, name, prices, headerText string) (result []Price) {
+	m := parsePrices(prices)
+	roles := p.roles.labeled(prices, len(m))
+	if roles == nil {
+		roles = p.roles.labeled(headerText, len(m))
+	}
+	if roles == nil {
+		roles = p.roles.counts[len(m)]
+	}
+	switch {
+	case len(m) == 0:
+		// regularly the case for slat dressing, so do not log
+		// log.Printf("%s: %s: did not find prices within \"%s\"\n", ids[id], name, prices)
+	case roles != nil:
+		result = make([]Price, len(m))
+		for j, price := range m {
+			result[j] = Price{
+				Price: price,
+				Role:  roles[j],
+			}
+		}
+	default:
+		p.warnf("%s: %s: did find %d prices but expected %s within \"%s\"\n", id, name, len(m), p.roles.expected(), prices)
+	}
+	return
+}
+
+// rePortion matches the portion labels of items sold in two sizes
+var rePortion = regexp.MustCompile(`(?i)(klein|gro(?:ß|ss))`)
+
+type portion struct {
+	label  string // "klein" or "groß"
+	prices string // part of the price cell text holding the portion's prices
+}
+
+// splitPortions splits a price cell text like "klein € 2,50 / groß € 3,80" or
+// "2,50 € (klein) / 3,80 € (groß)" into its portions; nil is returned unless
+// every portion label comes with prices
+func splitPortions(text string) []portion {
+	labels := rePortion.FindAllStringIndex(text, -1)
+	if len(labels) < 2 {
+		return nil
+	}
+	// labels either precede or follow their prices
+	trailing := len(parsePrices(text[:labels[0][0]])) > 0
+
+	portions := make([]portion, len(labels))
+	for i, l := range labels {
+		from, to := l[1], len(text)
+		if trailing {
+			from, to = 0, l[0]
+			if i > 0 {
+				from = labels[i-1][1]
+			}
+		} else if i+1 < len(labels) {
+			to = labels[i+1][0]
+		}
+		portions[i].label = "klein"
+		if strings.HasPrefix(strings.ToLower(text[l[0]:l[1]]), "g") {
+			portions[i].label = "groß"
+		}
+		portions[i].prices = text[from:to]
+		if len(parsePrices(portions[i].prices)) == 0 {
+			return nil
+		}
+	}
+	return portions
+}
+
+// errFeedUnchanged is returned by Meals if the feed may be kept and none of
+// its pages changed
+var errFeedUnchanged = errors.New("no meal page changed")
+
+// Meals fetches the days from anchor+daysBefore to anchor+daysAfter; hours,
+// if known, tell the days on which an empty page is worth a retry. If keep is
+// set, the feed written before is still there and is kept with
+// errFeedUnchanged when all pages are answered 304 Not Modified.
+func (p *Parser) Meals(ctx context.Context, id string, hours *Times, anchor time.Time, daysBefore, daysAfter int, keep bool) (*Canteen, error) {
+	dates := dateWindow(anchor, daysBefore, daysAfter)
+	docs := make([]*goquery.Document, len(dates))
+	unchanged := keep
+	for i, date := range dates {
+		doc, notModified, err := p.dayDoc(ctx, id, date)
+		if err != nil {
+			return nil, err
+		}
+		docs[i] = doc
+		unchanged = unchanged && notModified
+	}
+	if unchanged {
+		return nil, errFeedUnchanged
+	}
+
+	c := &Canteen{}
+	for i, date := range dates {
+		d, err := p.dayOf(id, date, docs[i])
+		// the page of an open day at times comes back empty for a moment
+		for k := 1; k <= p.cfg.RetryEmptyMeals && (err == nil || errors.Is(err, errUnrecognizedEmpty)) && d.closed() && hours.openOn(date); k++ {
+			log.Printf("%s: %s: no meals although open, fetching again (%d/%d)\n", id, date, k, p.cfg.RetryEmptyMeals)
+			if err := p.sleep(ctx, p.retryDelay(k)); err != nil {
+				return nil, err
+			}
+			d, err = p.Day(ctx, id, date)
+		}
+		if errors.Is(err, errUnrecognizedEmpty) {
+			p.warnf("%s: %s: %v, skipping\n", id, date, err)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if d.closed() && !p.cfg.EmitEmptyDays {
+			continue
+		}
+		if p.cfg.DedupeMeals {
+			d.dedupeMeals()
+		}
+		if p.cfg.SingleCategoryName != "" {
+			d.renameSingleCategory(p.cfg.SingleCategoryName)
+		}
+		c.Days = append(c.Days, d)
+	}
+
+	return c, nil
+}
+
+// dateWindow returns the ISO dates from anchor+daysBefore to anchor+daysAfter.
+// a date occurring twice (AddDate around a DST change) is only listed once,
+// openmensa rejects feeds with duplicate days.
+func dateWindow(anchor time.Time, daysBefore, daysAfter int) []string {
+	var dates []string
+	seen := map[string]bool{}
+	for i := daysBefore; i <= daysAfter; i++ {
+		date := anchor.AddDate(0, 0, i).Format("2006-01-02")
+		if seen[date] {
+			log.Printf("duplicate date %s in window, skipping\n", date)
+			continue
+		}
+		seen[date] = true
+		dates = append(dates, date)
+	}
+	return dates
+}
+
+var (
+	relativeDays = map[string]int{"vorgestern": -2, "gestern": -1, "heute": 0, "morgen": 1, "übermorgen": 2}
+	// weekdays are the lower-cased day names recognized in day labels and
+	// opening hours; extend it if the site changes its abbreviations
+	weekdays = map[string]time.Weekday{
+		"montag": time.Monday, "dienstag": time.Tuesday, "mittwoch": time.Wednesday, "donnerstag": time.Thursday,
+		"freitag": time.Friday, "samstag": time.Saturday, "sonnabend": time.Saturday, "sonntag": time.Sunday,
+		"mo": time.Monday, "di": time.Tuesday, "mi": time.Wednesday, "do": time.Thursday,
+		"fr": time.Friday, "sa": time.Saturday, "so": time.Sunday,
+	}
+	reLabelDate = regexp.MustCompile(`(\d{1,2})\.(\d{1,2})\.(\d{4}|\d{2})?`)
+)
+
+// weekdayPattern returns a case-insensitive regexp alternation of the names
+// in weekdays, longest first so that no abbreviation shadows a name
+func weekdayPattern() string {
+	names := make([]string, 0, len(weekdays))
+	for name := range weekdays {
+		names = append(names, regexp.QuoteMeta(name))
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if len(names[i]) != len(names[j]) {
+			return len(names[i]) > len(names[j])
+		}
+		return names[i] < names[j]
+	})
+	return "(?i:" + strings.Join(names, "|") + ")"
+}
+
+// weekdayIndex returns the index of the weekday named by token ("Mo",
+// "Sonnabend", …) within the week starting on Monday, as used by Times
+func weekdayIndex(token string) (int, bool) {
+	weekday, ok := weekdays[strings.ToLower(token)]
+	if !ok {
+		return 0, false
+	}
+	return (int(weekday) + 6) % 7, true
+}
+
+// resolveDayLabel turns a day header of a multi-day view into an ISO date.
+// Explicit dates ("14.10.2026", "14.10.") win; otherwise relative labels
+// ("heute", "morgen", …) are resolved against anchor, and German weekday
+// names to their next occurrence on or after anchor.
+func resolveDayLabel(label string, anchor time.Time) (string, bool) {
+	label = strings.ToLower(strings.TrimSpace(label))
+
+	if m := reLabelDate.FindStringSubmatch(label); m != nil {
+		day, _ := strconv.Atoi(m[1])
+		month, _ := strconv.Atoi(m[2])
+		year := anchor.Year()
+		if m[3] != "" {
+			year, _ = strconv.Atoi(m[3])
+			if year < 100 {
+				year += 2000
+			}
+		}
+		t := time.Date(year, time.Month(month), day, 0, 0, 0, 0, anchor.Location())
+		if t.Day() != day || int(t.Month()) != month {
+			return "", false
+		}
+		// dates without a year belong to the year change nearest to anchor
+		if m[3] == "" {
+			if t.Sub(anchor) > 180*24*time.Hour {
+				t = t.AddDate(-1, 0, 0)
+			} else if anchor.Sub(t) > 180*24*time.Hour {
+				t = t.AddDate(1, 0, 0)
+			}
+		}
+		return t.Format("2006-01-02"), true
+	}
+
+	for _, word := range strings.FieldsFunc(label, func(r rune) bool { return r == ',' || r == ' ' || r == '.' }) {
+		if offset, ok := relativeDays[word]; ok {
+			return anchor.AddDate(0, 0, offset).Format("2006-01-02"), true
+		}
+		if wd, ok := weekdays[word]; ok {
+			offset := (int(wd) - int(anchor.Weekday()) + 7) % 7
+			return anchor.AddDate(0, 0, offset).Format("2006-01-02"), true
+		}
+	}
+	return "", false
+}
+
+// loadIndex returns the ids of a previously generated index.json
+func loadIndex(filename string) ([]string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var index map[string]string
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	ids := make([]string, 0, len(index))
+	for id := range index {
+		if err := checkId(id); err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+		ids = append(ids, id)
+	}
+	log.Printf("processing the %d canteens of %s\n", len(ids), filename)
+	return ids, nil
+}
+
+// genIndex writes index.json, mapping the ids of the current canteens to the
+// URLs of their metadata
+func (p *Parser) genIndex(idsCur []string) error {
+	log.Println("generate", p.localPath(indexFile), "(index)")
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "{\n")
+	for i, id := range idsCur {
+		if err := checkId(id); err != nil {
+			return err
+		}
+		jsonId, err := json.Marshal(id)
+		if err != nil {
+			return err
+		}
+		jsonUrl, err := json.Marshal(p.feedUrl(id, metadataFile))
+		if err != nil {
+			return err
+		}
+		sep := ","
+		if i == len(idsCur)-1 {
+			sep = ""
+		}
+		fmt.Fprintf(&buf, "    %s: %s%s\n", jsonId, jsonUrl, sep)
+	}
+	fmt.Fprintf(&buf, "}\n")
+	return p.writeState(indexFile, buf.Bytes())
+}
+
+// checkId rejects ids that cannot be used as a line of the ids files, as a
+// key of index.json and as a directory name; json.Marshal takes care of
+// quotes and other characters that merely need escaping
+func checkId(id string) error {
+	if id == "" || id == "." || id == ".." {
+		return fmt.Errorf("invalid id %q", id)
+	}
+	if !utf8.ValidString(id) {
+		return fmt.Errorf("id %q is not valid UTF-8", id)
+	}
+	for _, r := range id {
+		if unicode.IsControl(r) || r == '/' || r == '\\' {
+			return fmt.Errorf("id %q contains %q", id, r)
+		}
+	}
+	return nil
+}
+
+// lessId defines the single ordering used wherever ids are emitted (index,
+// ids files, feeds): numeric ids compare numerically and sort before
+// non-numeric ones, which compare as strings. Ties fall back to string
+// comparison so that e.g. "01" and "1" stay distinct and stable.
+func lessId(a, b string) bool {
+	x, errA := strconv.Atoi(a)
+	y, errB := strconv.Atoi(b)
+	switch {
+	case errA == nil && errB == nil && x != y:
+		return x < y
+	case errA == nil && errB != nil:
+		return true
+	case errA != nil && errB == nil:
+		return false
+	}
+	return a < b
+}
+
+// idSlice implements unique.Interface using lessId
+type idSlice struct{ P *[]string }
+
+func (p idSlice) Len() int           { return len(*p.P) }
+func (p idSlice) Swap(i, j int)      { (*p.P)[i], (*p.P)[j] = (*p.P)[j], (*p.P)[i] }
+func (p idSlice) Less(i, j int) bool { return lessId((*p.P)[i], (*p.P)[j]) }
+func (p idSlice) Truncate(n int)     { *p.P = (*p.P)[:n] }
+
+// sortIds sorts ids by lessId and removes duplicates
+func sortIds(ids *[]string) {
+	unique.Sort(idSlice{ids})
+}
+
+// saveIds writes one id per line to the output file name
+func (p *Parser) saveIds(ids *[]string, name string) error {
+	log.Println("generate", p.localPath(name))
+
+	var buf bytes.Buffer
+	for _, id := range *ids {
+		if err := checkId(id); err != nil {
+			return err
+		}
+		fmt.Fprintln(&buf, id)
+	}
+	return p.writeState(name, buf.Bytes())
+}
+
+// writeState writes name, a file later runs read back from OutputDir; with an
+// archive output it is kept for saveState
+func (p *Parser) writeState(name string, data []byte) error {
+	if p.cfg.Archive != "" {
+		if p.state == nil {
+			p.state = make(map[string][]byte)
+		}
+		p.state[name] = data
+	}
+	return p.write(name, bytes.NewReader(data))
+}
+
+// saveState writes the state files kept by writeState to OutputDir, once
+// the archive holding them is published
+func (p *Parser) saveState() error {
+	names := make([]string, 0, len(p.state))
+	for name := range p.state {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	dir := fsSink{dir: p.cfg.OutputDir, mode: p.cfg.OutputMode}
+	for _, name := range names {
+		if err := dir.Write(name, bytes.NewReader(p.state[name])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readLines(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+func loadIds(filename string) ([]string, error) {
+	if _, err := os.Stat(filename); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	log.Println("restore IDs from", filename)
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
 	}
 	defer file.Close()
 
@@ -405,15 +1962,116 @@ func loadIds(filename string) ([]string, error) {
 	return ids, scanner.Err()
 }
 
-// set difference (b \ a) of two sorted lists
+// capIds trims the sorted ids all to at most max entries by dropping the
+// lowest ids not in cur, the archived canteens; current ids are always kept,
+// even if they alone exceed max
+func capIds(all, cur []string, max int) []string {
+	archived := diff(cur, all)
+	drop := len(all) - max
+	if drop <= 0 {
+		return all
+	}
+	if drop > len(archived) {
+		drop = len(archived)
+	}
+	for _, id := range archived[:drop] {
+		log.Printf("%s: dropping archived canteen from %s\n", id, idsAllFile)
+	}
+	return diff(archived[:drop], all)
+}
+
+// advanceMark returns the high-water mark (a single id, none if empty) moved
+// up along the sorted ids above it as long as done holds for them
+func advanceMark(mark, ids []string, done func(id string) bool) []string {
+	for _, id := range ids {
+		if len(mark) > 0 && !lessId(mark[0], id) {
+			continue
+		}
+		if !done(id) {
+			break
+		}
+		mark = []string{id}
+	}
+	return mark
+}
+
+// equalIds reports whether a and b list the same ids in the same order
+func equalIds(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// idsSorted reports whether ids are strictly ascending by lessId, i.e. as
+// left by sortIds
+func idsSorted(ids []string) bool {
+	for i := 1; i < len(ids); i++ {
+		if !lessId(ids[i-1], ids[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeIds returns the ids of the state file name sorted and deduped,
+// without the entries saveIds would reject (blank lines of a file edited by
+// hand); it warns about any of that
+func normalizeIds(ids []string, name string) []string {
+	valid := ids[:0]
+	for _, id := range ids {
+		if err := checkId(id); err != nil {
+			log.Printf("%s: dropping %s\n", name, err)
+			continue
+		}
+		valid = append(valid, id)
+	}
+	if !idsSorted(valid) {
+		log.Printf("%s is not sorted or contains duplicates (edited by hand?), normalizing it\n", name)
+		sortIds(&valid)
+	}
+	return valid
+}
+
+// filterIds keeps the ids that are in include (if not empty) and not in
+// exclude
+func filterIds(ids, include, exclude []string) []string {
+	if len(include) == 0 && len(exclude) == 0 {
+		return ids
+	}
+	contains := func(list []string, id string) bool {
+		for _, x := range list {
+			if x == id {
+				return true
+			}
+		}
+		return false
+	}
+
+	var filtered []string
+	for _, id := range ids {
+		if (len(include) == 0 || contains(include, id)) && !contains(exclude, id) {
+			filtered = append(filtered, id)
+		}
+	}
+	log.Printf("filtered %d of %d ids by -include-ids/-exclude-ids\n", len(ids)-len(filtered), len(ids))
+	return filtered
+}
+
+// set difference (b \ a) of two lists sorted by sortIds
 func diff(a, b []string) []string {
 	diff := make([]string, len(b))
 
 	i, j, k := 0, 0, 0
 	for i < len(a) && j < len(b) {
-		if a[i] < b[j] {
+		if lessId(a[i], b[j]) {
 			i++
-		} else if a[i] > b[j] {
+		} else if lessId(b[j], a[i]) {
 			diff[k] = b[j]
 			k++
 			j++
@@ -430,73 +2088,632 @@ func diff(a, b []string) []string {
 }
 
 func main() {
-	idsCur := fetchIds()
-	unique.Sort(unique.StringSlice{&idsCur})
-
-	idsAll, err := loadIds(idsAllFile)
-	if err != nil {
+	cfg, err := parseFlags(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	} else if err != nil {
 		log.Fatal(err)
 	}
-	idsAll = append(idsAll, idsCur...)
-	unique.Sort(unique.StringSlice{&idsAll})
 
-	idsArchive := diff(idsCur, idsAll)
+	// exit status 2: the listing could not be fetched and nothing was
+	// generated, 1: any other error, possibly after a partial run
+	if err := run(cfg); err != nil {
+		log.Println(err)
+		var listingErr *ListingError
+		if errors.As(err, &listingErr) {
+			os.Exit(2)
+		}
+		os.Exit(1)
+	}
+}
+
+func run(cfg Config) error {
+	return runParser(NewParser(cfg))
+}
+
+// runParser is run with the Parser p, whose output may be any sink
+func runParser(p *Parser) error {
+	cfg := p.cfg
+	// all dates of this run are computed relative to the same day
+	anchor := p.anchor
+
+	if cfg.IconReport != "" {
+		p.icons = newIconProbe()
+		defer func() {
+			if err := p.icons.write(cfg.IconReport, cfg.OutputMode); err != nil {
+				log.Println(err)
+			}
+		}()
+	}
+
+	if cfg.GoldenDir != "" {
+		if err := mkdirAll(cfg.OutputDir, cfg.OutputMode); err != nil {
+			return err
+		}
+		if err := compareGolden(p, cfg.GoldenDir, cfg.UpdateGolden); err != nil {
+			return err
+		}
+		return p.checkStrict()
+	}
+
+	// the report is written even if the run fails
+	if cfg.ErrorsReport != "" {
+		defer func() {
+			if err := p.errors.write(cfg.ErrorsReport, cfg.OutputMode); err != nil {
+				log.Println(err)
+			}
+		}()
+	}
+	if cfg.RequestLog != "" {
+		file, err := openFile(cfg.RequestLog, os.O_TRUNC, cfg.OutputMode)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		p.requestLog = newRequestLogger(file)
+	}
+	if cfg.Trace != "" || cfg.TraceOTLP != "" {
+		var w io.Writer
+		if cfg.Trace != "" {
+			file, err := openFile(cfg.Trace, os.O_TRUNC, cfg.OutputMode)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			w = file
+		}
+		p.tracer = newSpanTracer(w, cfg.TraceOTLP)
+		defer p.tracer.close()
+	}
+
+	// on SIGINT/SIGTERM in-flight fetches are cancelled; canteens already
+	// written stay, the interrupted one is not written at all
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if cfg.RunTimeout > 0 {
+		// the deadline is inherited by every request of the run
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.RunTimeout)
+		defer cancel()
+	}
+	defer func() { log.Println(p.retrySummary()) }()
 
-	err = saveIds(&idsCur, idsCurFile)
+	fatal := func(id, phase string, err error) error {
+		p.errors.add(id, phase, err, true)
+		return err
+	}
+
+	// like single files the archive is only published if the run got
+	// through, errors of single canteens included
+	complete := false
+	if cfg.Archive != "" {
+		a, err := openArchive(cfg.Archive, cfg.OutputMode)
+		if err != nil {
+			return err
+		}
+		p.output = a
+		defer func() {
+			err := a.close(complete)
+			if err == nil && complete {
+				err = p.saveState()
+			}
+			if err != nil {
+				log.Println(err)
+			}
+		}()
+	}
+
+	var idsCur []string
+	var err error
+	if cfg.FromIndex {
+		idsCur, err = loadIndex(p.localPath(indexFile))
+	} else {
+		sp := p.tracer.start("fetchIds")
+		idsCur, err = p.FetchIds(ctx)
+		sp.end(err)
+	}
 	if err != nil {
-		log.Fatal(err)
+		return fatal("", "listing", err)
 	}
-	err = saveIds(&idsArchive, idsArchiveFile)
+	sortIds(&idsCur)
+
+	idsAll, err := loadIds(filepath.Join(cfg.OutputDir, idsAllFile))
 	if err != nil {
-		log.Fatal(err)
+		return fatal("", "ids", err)
 	}
-	err = saveIds(&idsAll, idsAllFile)
+	idsAll = normalizeIds(idsAll, idsAllFile)
+	// the previous archive tells canteens that reappeared in the listing
+	// apart from really new ones
+	idsArchivePrev, err := loadIds(p.localPath(idsArchiveFile))
 	if err != nil {
-		log.Fatal(err)
+		return fatal("", "ids", err)
+	}
+	sortIds(&idsArchivePrev)
+	idsReappeared := diff(diff(idsArchivePrev, idsCur), idsCur)
+	idsAdded := diff(idsAll, idsCur)
+	for _, id := range idsAdded {
+		log.Printf("%s: new canteen\n", id)
+	}
+
+	idsAll = append(idsAll, idsCur...)
+	sortIds(&idsAll)
+	if cfg.MaxIdsAll > 0 {
+		idsAll = capIds(idsAll, idsCur, cfg.MaxIdsAll)
+	}
+
+	idsArchive := diff(idsCur, idsAll)
+	// ids_current and the index are written after the metadata, which may
+	// exclude closed canteens
+	idsListed := idsCur
+
+	// -include-ids and -exclude-ids limit the canteens processed and indexed,
+	// the state files still follow the whole listing; reappeared canteens
+	// stay in the archive until a run processes them
+	idsFiltered := filterIds(idsCur, cfg.IncludeIds, cfg.ExcludeIds)
+	idsProcess := idsFiltered
+	if idsHeld := diff(idsProcess, idsReappeared); len(idsHeld) > 0 {
+		idsReappeared = diff(idsHeld, idsReappeared)
+		idsListed = diff(idsHeld, idsListed)
+		idsArchive = append(idsArchive, idsHeld...)
+		sortIds(&idsArchive)
+	}
+	// whether they really reappeared is only known once their metadata has
+	// been checked for a closure
+	reappeared := make(map[string]bool)
+	for _, id := range idsReappeared {
+		reappeared[id] = true
 	}
 
-	err = genIndex(idsCur, idsArchive)
+	// ids above the high-water mark of the previous run are the new ones
+	mark, err := loadIds(p.localPath(idsMarkFile))
 	if err != nil {
-		log.Fatal(err)
+		return fatal("", "ids", err)
+	}
+	if cfg.SinceID && len(mark) > 0 {
+		var idsNew []string
+		for _, id := range idsProcess {
+			// reappeared canteens are usually below the mark but their
+			// files are outdated
+			if lessId(mark[0], id) || reappeared[id] {
+				idsNew = append(idsNew, id)
+			}
+		}
+		log.Printf("processing %d ids above high-water mark %s or reappeared\n", len(idsNew), mark[0])
+		idsProcess = idsNew
 	}
+	processed := make(map[string]bool)
+	for _, id := range idsProcess {
+		processed[id] = true
+	}
+
+	// metadata parsed in this run and the latest "Stand" date of the meal
+	// pages, by id
+	metadata := make(map[string]*Canteen)
+	updated := make(map[string]string)
+	var metadataMu sync.Mutex
 
 	// generate metadata files
-	for _, id := range idsCur {
-		path := repo + id
-		if err := os.MkdirAll(path, os.ModePerm); err != nil {
-			log.Fatal(err)
+	err = forEachId(idsProcess, cfg.Concurrency, p.errors, func(id string) error {
+		filename := p.outputPath(id, metadataFile)
+		if cfg.NoMetadata && !reappeared[id] {
+			// canteens without metadata yet still need one
+			if _, err := os.Stat(filename); err == nil {
+				return nil
+			}
 		}
-		filename := path + "/metadata.xml"
 		log.Println("generate", filename, "(metadata)")
-		file, err := os.Create(filename)
-		if err != nil {
-			log.Fatal(err)
+		sp := p.tracer.start("metadata", "id", id)
+		c, err := p.Metadata(ctx, id)
+		sp.end(err)
+		if abortsRun(err) {
+			return fatal(id, "metadata", err)
+		} else if err != nil {
+			// keep the previous file and continue with the other canteens
+			log.Printf("%s: %s\n", id, err)
+			p.errors.add(id, "metadata", err, false)
+			return nil
 		}
-		defer file.Close()
+		if _, err := p.writeCanteen(p.relPath(id, metadataFile), c); err != nil {
+			return fatal(id, "metadata", err)
+		}
+		if cfg.ICS {
+			ics, err := genICS(id, c, anchor)
+			if err != nil {
+				log.Printf("%s: %s\n", id, err)
+				p.errors.add(id, "ics", err, false)
+			} else if ics != nil {
+				if err := p.write(p.relPath(id, icsFile), bytes.NewReader(ics)); err != nil {
+					return fatal(id, "ics", err)
+				}
+			}
+		}
+		metadataMu.Lock()
+		metadata[id] = c
+		metadataMu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// canteens closed long-term move to the archive, keeping their metadata;
+	// archived canteens whose metadata failed stay there, they may still be
+	// closed
+	if cfg.ExcludeClosed {
+		var idsClosed []string
+		for _, id := range idsProcess {
+			c := metadata[id]
+			if c != nil && c.Closure != "" {
+				log.Printf("%s: excluding canteen, `%s`\n", id, c.Closure)
+				idsClosed = append(idsClosed, id)
+			} else if c == nil && reappeared[id] {
+				idsClosed = append(idsClosed, id)
+			}
+		}
+		idsListed = diff(idsClosed, idsListed)
+		idsProcess = diff(idsClosed, idsProcess)
+		idsReappeared = diff(idsClosed, idsReappeared)
+		idsArchive = append(idsArchive, idsClosed...)
+		sortIds(&idsArchive)
+	}
+	for _, id := range idsReappeared {
+		log.Printf("%s: canteen reappeared, moving it back from the archive\n", id)
+	}
 
-		if err := getMetadata(id).Write(file); err != nil {
-			log.Fatal(err)
+	var cp *checkpoint
+	lastDate := anchor.AddDate(0, 0, cfg.DaysAfter).Format("2006-01-02")
+	if cfg.Checkpoint != "" {
+		cp, err = loadCheckpoint(cfg.Checkpoint, cfg.OutputMode)
+		if err != nil {
+			return fatal("", "checkpoint", err)
 		}
 	}
 
 	// full feed
-	for _, id := range idsCur {
-		path := repo + id
-		if err := os.MkdirAll(path, os.ModePerm); err != nil {
-			log.Fatal(err)
+	err = forEachId(idsProcess, cfg.Concurrency, p.errors, func(id string) error {
+		if cp != nil && cp.done(id, lastDate) {
+			log.Printf("%s: feed already written up to %s according to the checkpoint\n", id, lastDate)
+			return nil
+		}
+		log.Println("generate", p.outputPath(id, fullFile), "(feed full)")
+
+		metadataMu.Lock()
+		m := metadata[id]
+		metadataMu.Unlock()
+		var hours *Times
+		if m != nil {
+			hours = m.Times
 		}
 
-		filename := path + "/full.xml"
-		log.Println("generate", filename, "(feed full)")
+		feeds := []string{fullFile}
+		if cfg.TodayFeed {
+			feeds = append(feeds, todayFile)
+		}
+		sp := p.tracer.start("meals", "id", id)
+		c, err := p.Meals(ctx, id, hours, anchor, cfg.DaysBefore, cfg.DaysAfter, p.cache != nil && p.written(id, feeds))
+		sp.end(err)
+		if errors.Is(err, errFeedUnchanged) {
+			log.Printf("%s: %s, keeping the feed\n", id, err)
+			if err := p.keepFeeds(id, feeds); err != nil {
+				return fatal(id, "feed", err)
+			}
+			if cp != nil {
+				if err := cp.mark(id, lastDate); err != nil {
+					return fatal(id, "checkpoint", err)
+				}
+			}
+			return nil
+		} else if abortsRun(err) {
+			return fatal(id, "feed", err)
+		} else if err != nil {
+			log.Printf("%s: %s\n", id, err)
+			p.errors.add(id, "feed", err, false)
+			return nil
+		}
+		if cfg.MinMeals > 0 {
+			p.checkMealCount(id, c.Days, hours, cfg.MinMeals)
+		}
+		metadataMu.Lock()
+		for _, d := range c.Days {
+			if d.Updated > updated[id] {
+				updated[id] = d.Updated
+			}
+		}
+		metadataMu.Unlock()
+		if cfg.Stamp {
+			c.Comment = fmt.Sprintf("generated %s by openmensa-parser-berlin %s", anchor.UTC().Format(time.RFC3339), toolVersion())
+		}
+		changed, err := p.writeCanteen(p.relPath(id, fullFile), c)
+		if err != nil {
+			return fatal(id, "feed", err)
+		}
+		if changed && cfg.NotifyURL != "" {
+			p.notifyChange(ctx, id, p.feedUrl(id, fullFile))
+		}
+		if cfg.TodayFeed {
+			log.Println("generate", p.outputPath(id, todayFile), "(feed today)")
+			changed, err := p.writeCanteen(p.relPath(id, todayFile), c.today(anchor))
+			if err != nil {
+				return fatal(id, "feed", err)
+			}
+			if changed && cfg.NotifyURL != "" {
+				p.notifyChange(ctx, id, p.feedUrl(id, todayFile))
+			}
+		}
+		if cp != nil {
+			if err := cp.mark(id, lastDate); err != nil {
+				return fatal(id, "checkpoint", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
 
-		file, err := os.Create(filename)
+	// the state only moves on once the run got through, so that an aborted
+	// run is repeated as a whole: its new canteens stay new, and the mark
+	// only passes canteens processed without errors
+	err = p.saveIds(&idsListed, idsCurFile)
+	if err != nil {
+		return fatal("", "ids", err)
+	}
+	err = p.saveIds(&idsArchive, idsArchiveFile)
+	if err != nil {
+		return fatal("", "ids", err)
+	}
+	// the index must not point at metadata this run did not write
+	idsIndexed := idsListed
+	if len(cfg.IncludeIds) > 0 || len(cfg.ExcludeIds) > 0 {
+		idsIndexed = diff(diff(idsFiltered, idsListed), idsListed)
+	}
+	err = p.genIndex(idsIndexed)
+	if err != nil {
+		return fatal("", "index", err)
+	}
+	if cfg.Catalog {
+		if err := p.genCatalog(idsIndexed, metadata, updated, anchor.Format("2006-01-02")); err != nil {
+			return fatal("", "catalog", err)
+		}
+	}
+	if cfg.EventLog != "" {
+		events := idEvents(anchor, idsAdded, idsReappeared, diff(idsArchivePrev, idsArchive))
+		if err := appendEvents(cfg.EventLog, events, cfg.OutputMode); err != nil {
+			return fatal("", "events", err)
+		}
+	}
+	err = p.saveIds(&idsAll, idsAllFile)
+	if err != nil {
+		return fatal("", "ids", err)
+	}
+	failed := p.errors.ids()
+	if m := advanceMark(mark, idsCur, func(id string) bool { return processed[id] && !failed[id] }); !equalIds(m, mark) {
+		err = p.saveIds(&m, idsMarkFile)
 		if err != nil {
-			log.Fatal(err)
+			return fatal("", "ids", err)
 		}
-		defer file.Close()
+	}
+	complete = true
+	if cp != nil {
+		if err := cp.clear(); err != nil {
+			return fatal("", "checkpoint", err)
+		}
+	}
+
+	if cfg.VerifyURLs {
+		p.verifyUrls(ctx, idsProcess, cfg.VerifySample)
+	}
+
+	if n := p.errors.len(); n > 0 {
+		return fmt.Errorf("run finished with %d errors, see the log above", n)
+	}
+	return p.checkStrict()
+}
+
+// checkStrict fails with -strict if any parse warning occurred
+func (p *Parser) checkStrict() error {
+	if n := p.warnings.len(); p.cfg.Strict && n > 0 {
+		return fmt.Errorf("strict: %d parse warnings, see the log above", n)
+	}
+	return nil
+}
+
+// checkMealCount warns about days with fewer than min meals on which the
+// canteen is open according to hours; such days are more likely a parse
+// failure than reality. Closed days are left to the closed detection.
+func (p *Parser) checkMealCount(id string, days []Day, hours *Times, min int) {
+	if hours == nil {
+		return
+	}
+	for _, d := range days {
+		if !hours.openOn(d.Date) {
+			continue
+		}
+		var n int
+		for _, c := range d.Categories {
+			n += len(c.Meals)
+		}
+		if n > 0 && n < min {
+			p.warnf("%s: %s: only %d meals on an open day, expected at least %d\n", id, d.Date, n, min)
+		}
+	}
+}
+
+// relPath returns the slash-separated path of a canteen's file relative to
+// the output directory according to OutputLayout
+func (p *Parser) relPath(id, file string) string {
+	switch p.cfg.OutputLayout {
+	case "flat":
+		return id + "-" + file
+	default: // "nested"
+		return id + "/" + file
+	}
+}
+
+// localPath returns the local path of the slash-separated output name
+func (p *Parser) localPath(name string) string {
+	return filepath.Join(p.cfg.OutputDir, filepath.FromSlash(name))
+}
+
+// outputPath returns the local path of a canteen's file
+func (p *Parser) outputPath(id, file string) string {
+	return p.localPath(p.relPath(id, file))
+}
+
+// feedUrl returns the URL a canteen's file is published at
+func (p *Parser) feedUrl(id, file string) string {
+	return p.cfg.FeedBase + p.relPath(id, file)
+}
+
+// write passes a generated file to the output of the run
+func (p *Parser) write(name string, r io.Reader) (err error) {
+	sp := p.tracer.start("write", "path", name)
+	defer func() { sp.end(err) }()
+	return p.output.Write(name, r)
+}
+
+// writeCanteen writes c to the output name and reports whether the content
+// changed, the comment aside; it is only called with fully fetched data so
+// that an aborted run never leaves a truncated file behind
+func (p *Parser) writeCanteen(name string, c *Canteen) (bool, error) {
+	c.CompactNotes = p.cfg.CompactNotes
+	var buf bytes.Buffer
+	if err := c.Write(&buf); err != nil {
+		return false, err
+	}
+
+	changed := true
+	if old, err := os.ReadFile(p.localPath(name)); err == nil {
+		changed = sha256.Sum256(stripComment(old)) != sha256.Sum256(stripComment(buf.Bytes()))
+	}
+	return changed, p.write(name, &buf)
+}
+
+// written reports whether the files of canteen id were all written before
+func (p *Parser) written(id string, files []string) bool {
+	for _, file := range files {
+		if _, err := os.Stat(p.localPath(p.relPath(id, file))); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// keepFeeds writes the files of canteen id as written before, so that they
+// are part of the output of the run whatever the sink
+func (p *Parser) keepFeeds(id string, files []string) error {
+	for _, file := range files {
+		name := p.relPath(id, file)
+		data, err := os.ReadFile(p.localPath(name))
+		if err != nil {
+			return err
+		}
+		if err := p.write(name, bytes.NewReader(data)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stripComment returns the feed data without the comment Canteen.Write puts
+// before the canteen, which -stamp changes in every run
+func stripComment(data []byte) []byte {
+	prefix := []byte(xmlHeader + xmlIndent + "<!-- ")
+	if !bytes.HasPrefix(data, prefix) {
+		return data
+	}
+	end := bytes.Index(data, []byte(" -->\n"))
+	if end < 0 {
+		return data
+	}
+	return append([]byte(xmlHeader), data[end+len(" -->\n"):]...)
+}
 
-		if err := getMeals(id, -1, 21).Write(file); err != nil {
-			log.Fatal(err)
+// writeFileAtomic replaces filename with data via a temporary file in the
+// same directory, so readers (and interrupted runs) never see partial files;
+// mode is the configured OutputMode, see there
+func writeFileAtomic(filename string, data []byte, mode fileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	// keep the mode of an existing file unless configured, temporary files
+	// are created 0600
+	perm := mode.perm()
+	if fi, err := os.Stat(filename); err == nil && mode == 0 {
+		perm = fi.Mode().Perm()
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+// notifyChange tells NotifyURL that the feed of a canteen changed; it is
+// best-effort, failures are only logged
+func (p *Parser) notifyChange(ctx context.Context, id, feedUrl string) {
+	body, err := json.Marshal(struct {
+		Id  string `json:"id"`
+		Url string `json:"url"`
+	}{id, feedUrl})
+	if err != nil {
+		log.Printf("%s: notify: %s\n", id, err)
+		return
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.cfg.NotifyURL, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("%s: notify: %s\n", id, err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		log.Printf("%s: notify: %s\n", id, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		log.Printf("%s: notify: got status code %d\n", id, resp.StatusCode)
+	}
+}
+
+// verifyUrls HEAD-checks the published feed URLs of ids, or of an evenly
+// spaced sample of them if sample is positive, and records every URL that
+// does not resolve as an error of the run
+func (p *Parser) verifyUrls(ctx context.Context, ids []string, sample int) {
+	step := 1
+	if sample > 0 && len(ids) > sample {
+		step = (len(ids) + sample - 1) / sample
+	}
+	for i := 0; i < len(ids); i += step {
+		id := ids[i]
+		url := p.feedUrl(id, fullFile)
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+		if err != nil {
+			p.errors.add(id, "verify", err, false)
+			continue
+		}
+		resp, err := p.client.Do(req)
+		if err != nil {
+			log.Printf("%s: verify: %s\n", id, err)
+			p.errors.add(id, "verify", err, false)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			err := fmt.Errorf("%s: got status code %d", url, resp.StatusCode)
+			log.Printf("%s: verify: %s\n", id, err)
+			p.errors.add(id, "verify", err, false)
 		}
 	}
 }
@@ -0,0 +1,3724 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// testDay is a day page with a single meal
+const testDay = `<html><body>
+<div class="splGroupWrapper"><div class="splGroup">Essen</div>
+<div class="splMeal"><span class="bold">Schnitzel</span><div class="text-right">€ 1,95/3,10/4,65</div></div>
+</div>
+</body></html>`
+
+// testSite fakes the endpoints of the site: the listing of ids, a metadata
+// page per id and the same day page for all of them
+type testSite struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	ids      []string            // the listing
+	info     map[string]string   // additional HTML of the metadata page by id
+	status   map[string]int      // status code of the metadata page by id
+	day      string              // body of every day page
+	etag     bool                // tag the day pages, answering 304 to a match
+	hang     map[string]bool     // ids whose day pages are never answered
+	days     []string            // bodies of the next day pages, before day
+	truncate int                 // next day pages whose connection drops mid-body
+	headers  map[string][]string // requests by path, as "If-None-Match" value
+	requests map[string]int      // requests by path
+}
+
+func newTestSite(t testing.TB, ids ...string) *testSite {
+	s := &testSite{
+		ids:      ids,
+		info:     make(map[string]string),
+		status:   make(map[string]int),
+		hang:     make(map[string]bool),
+		day:      testDay,
+		headers:  make(map[string][]string),
+		requests: make(map[string]int),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *testSite) serve(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests[r.URL.Path]++
+	s.headers[r.URL.Path] = append(s.headers[r.URL.Path], r.Header.Get("If-None-Match"))
+
+	id := r.Form.Get("resources_id")
+	switch r.URL.Path {
+	case "/meta":
+		if code := s.status[id]; code != 0 {
+			w.WriteHeader(code)
+			return
+		}
+		fmt.Fprint(w, s.metaPage(id))
+	case "/day":
+		if s.hang[id] {
+			s.mu.Unlock()
+			<-r.Context().Done()
+			s.mu.Lock()
+			return
+		}
+		if s.truncate > 0 {
+			s.truncate--
+			s.cutOff(w, s.day)
+			return
+		}
+		if len(s.days) > 0 {
+			fmt.Fprint(w, s.days[0])
+			s.days = s.days[1:]
+			return
+		}
+		if s.etag {
+			tag := fmt.Sprintf(`"%x"`, sha256.Sum256([]byte(s.day)))
+			w.Header().Set("ETag", tag)
+			if r.Header.Get("If-None-Match") == tag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		fmt.Fprint(w, s.day)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// cutOff announces body in full but closes the connection after half of it
+func (s *testSite) cutOff(w http.ResponseWriter, body string) {
+	conn, buf, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		panic(err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(buf, "HTTP/1.1 200 OK\r\nContent-Type: text/html\r\nContent-Length: %d\r\n\r\n", len(body))
+	buf.WriteString(body[:len(body)/2])
+	buf.Flush()
+}
+
+// metaPage returns the metadata page of id, whose listbox is the listing
+func (s *testSite) metaPage(id string) string {
+	var options strings.Builder
+	for _, listed := range s.ids {
+		selected := ""
+		if listed == id {
+			selected = ` selected="selected"`
+		}
+		fmt.Fprintf(&options, `<option value="%s"%s>Mensa %s</option>`, listed, selected, listed)
+	}
+	return `<html><body>
+<select id="listboxEinrichtungen" class="listboxStandorte">` + options.String() + `</select>
+<div><i class="glyphicon glyphicon-map-marker"></i></div><div>Hardenbergstr. 34
+10623 Berlin (Bezirk Charlottenburg)</div>
+<div><div><i class="glyphicon glyphicon-time"></i></div></div>
+<div>Mo. – So.
+ 11:00 – 14:30 Uhr</div>
+` + s.info[id] + `
+</body></html>`
+}
+
+// set changes the site while runs may be going on
+func (s *testSite) set(f func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f()
+}
+
+// config returns a configuration scraping s into a new directory, with a
+// one-day window and quick retries
+func (s *testSite) config(t testing.TB) Config {
+	cfg := defaultConfig()
+	cfg.MetaURL = s.URL + "/meta"
+	cfg.MealURL = s.URL + "/day"
+	cfg.DefaultID = "0"
+	cfg.OutputDir = t.TempDir()
+	cfg.FeedBase = "https://feeds.example/"
+	cfg.DaysBefore, cfg.DaysAfter = 0, 0
+	cfg.MaxRetries, cfg.ListingRetries = 2, 2
+	cfg.RetryStep, cfg.RetryMax = time.Millisecond, time.Millisecond
+	return cfg
+}
+
+// readIds returns the ids of the state file name below dir
+func readIds(t *testing.T, dir, name string) []string {
+	t.Helper()
+	ids, err := loadIds(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+// readJSON decodes the JSON file filename into v
+func readJSON(t *testing.T, filename string, v interface{}) {
+	t.Helper()
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatalf("%s: %s", filename, err)
+	}
+}
+
+// TestParallelRuns runs against two sites at the same time; the errors and
+// parse warnings of one run must neither show up in nor fail the other
+func TestParallelRuns(t *testing.T) {
+	bad := newTestSite(t, "1", "2")
+	bad.status["2"] = http.StatusInternalServerError
+	bad.day = strings.Replace(testDay, `<div class="text-right">`, `<img class="splIcon" src="/unknown.png"><div class="text-right">`, 1)
+	good := newTestSite(t, "1", "2")
+
+	reports := t.TempDir()
+	cfgs := []Config{bad.config(t), good.config(t)}
+	for i := range cfgs {
+		cfgs[i].ErrorsReport = filepath.Join(reports, fmt.Sprintf("errors%d.json", i))
+		cfgs[i].Strict = true
+		cfgs[i].Concurrency = 2
+	}
+
+	errs := make([]error, len(cfgs))
+	var wg sync.WaitGroup
+	for i := range cfgs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = run(cfgs[i])
+		}(i)
+	}
+	wg.Wait()
+
+	if errs[0] == nil {
+		t.Error("run against the failing site succeeded")
+	}
+	if errs[1] != nil {
+		t.Errorf("run against the good site failed: %s", errs[1])
+	}
+
+	var badErrors, goodErrors []RunError
+	readJSON(t, cfgs[0].ErrorsReport, &badErrors)
+	readJSON(t, cfgs[1].ErrorsReport, &goodErrors)
+	if len(badErrors) != 1 || badErrors[0].Id != "2" || badErrors[0].Phase != "metadata" {
+		t.Errorf("errors of the failing site: %+v", badErrors)
+	}
+	if len(goodErrors) != 0 {
+		t.Errorf("errors of the good site: %+v", goodErrors)
+	}
+	for i, cfg := range cfgs {
+		if ids := readIds(t, cfg.OutputDir, idsCurFile); !equalIds(ids, []string{"1", "2"}) {
+			t.Errorf("run %d: ids_current %v", i, ids)
+		}
+	}
+}
+
+// readEvents returns the events of the log filename as "event id"
+func readEvents(t *testing.T, filename string) []string {
+	t.Helper()
+	lines, err := readLines(filename)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	var events []string
+	for _, line := range lines {
+		var e idEvent
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("%s: %s", filename, err)
+		}
+		events = append(events, e.Event+" "+e.Id)
+	}
+	return events
+}
+
+// TestFilterKeepsState checks that -include-ids limits the canteens
+// processed but not the state derived from the listing
+func TestFilterKeepsState(t *testing.T) {
+	site := newTestSite(t, "1", "2", "3")
+	cfg := site.config(t)
+	cfg.EventLog = filepath.Join(t.TempDir(), "events.jsonl")
+
+	filtered := cfg
+	filtered.IncludeIds = idList{"2"}
+	if err := run(filtered); err != nil {
+		t.Fatal(err)
+	}
+	if ids := readIds(t, cfg.OutputDir, idsCurFile); !equalIds(ids, []string{"1", "2", "3"}) {
+		t.Errorf("ids_current after the filtered run: %v", ids)
+	}
+	if ids := readIds(t, cfg.OutputDir, idsArchiveFile); len(ids) != 0 {
+		t.Errorf("ids_archive after the filtered run: %v", ids)
+	}
+	for _, id := range []string{"1", "3"} {
+		if _, err := os.Stat(filepath.Join(cfg.OutputDir, id, fullFile)); err == nil {
+			t.Errorf("canteen %s was processed despite -include-ids", id)
+		}
+	}
+	var index map[string]string
+	readJSON(t, filepath.Join(cfg.OutputDir, indexFile), &index)
+	if len(index) != 1 || index["2"] == "" {
+		t.Errorf("index after the filtered run: %v", index)
+	}
+
+	// 3 leaves the listing and comes back while filtered out
+	site.set(func() { site.ids = []string{"1", "2"} })
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	site.set(func() { site.ids = []string{"1", "2", "3"} })
+	if err := run(filtered); err != nil {
+		t.Fatal(err)
+	}
+	if ids := readIds(t, cfg.OutputDir, idsArchiveFile); !equalIds(ids, []string{"3"}) {
+		t.Errorf("ids_archive after reappearing while filtered out: %v", ids)
+	}
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if ids := readIds(t, cfg.OutputDir, idsArchiveFile); len(ids) != 0 {
+		t.Errorf("ids_archive after the unfiltered run: %v", ids)
+	}
+
+	want := []string{"new 1", "new 2", "new 3", "archived 3", "reappeared 3"}
+	if events := readEvents(t, cfg.EventLog); strings.Join(events, ", ") != strings.Join(want, ", ") {
+		t.Errorf("events %v, want %v", events, want)
+	}
+}
+
+// TestExcludeIds checks that excluded canteens are neither processed nor
+// indexed, and that an id cannot be included and excluded at once
+func TestExcludeIds(t *testing.T) {
+	site := newTestSite(t, "1", "2", "3")
+	cfg := site.config(t)
+	cfg.ExcludeIds = idList{"2"}
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	var index map[string]string
+	readJSON(t, filepath.Join(cfg.OutputDir, indexFile), &index)
+	if len(index) != 2 || index["1"] == "" || index["3"] == "" {
+		t.Errorf("index: %v", index)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.OutputDir, "2")); err == nil {
+		t.Error("excluded canteen was processed")
+	}
+
+	for _, test := range []struct {
+		args []string
+		ok   bool
+	}{
+		{[]string{"-include-ids", "1,2", "-exclude-ids", "3"}, true},
+		{[]string{"-include-ids", "1,2", "-exclude-ids", "2"}, false},
+	} {
+		if _, err := parseFlags(test.args); (err == nil) != test.ok {
+			t.Errorf("%v: error %v", test.args, err)
+		}
+	}
+}
+
+// TestNormalizeIdsAll checks that a hand-edited ids_all is sorted, deduped
+// and rid of blank lines instead of failing the run when it is saved
+func TestNormalizeIdsAll(t *testing.T) {
+	site := newTestSite(t, "1")
+	cfg := site.config(t)
+	if err := os.WriteFile(filepath.Join(cfg.OutputDir, idsAllFile), []byte("3\n2\n\n3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	logged := captureLog(t, func() { err = run(cfg) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(logged, "normalizing it") || !strings.Contains(logged, `dropping invalid id ""`) {
+		t.Errorf("no warning about ids_all in:\n%s", logged)
+	}
+	if ids := readIds(t, cfg.OutputDir, idsAllFile); !equalIds(ids, []string{"1", "2", "3"}) {
+		t.Errorf("ids_all %v", ids)
+	}
+}
+
+// captureLog returns what f logs
+func captureLog(t *testing.T, f func()) string {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	f()
+	return buf.String()
+}
+
+// testClosure is a banner announcing a long-term closure
+const testClosure = `<div class="alert alert-info">Die Mensa ist bis auf Weiteres geschlossen.</div>`
+
+// TestExcludeClosedStaysArchived checks that a listed canteen archived for
+// its closure is not moved back and forth on later runs
+func TestExcludeClosedStaysArchived(t *testing.T) {
+	site := newTestSite(t, "1", "2")
+	site.info["2"] = testClosure
+	cfg := site.config(t)
+	cfg.ExcludeClosed = true
+
+	for i := 1; i <= 2; i++ {
+		logged := captureLog(t, func() {
+			if err := run(cfg); err != nil {
+				t.Fatal(err)
+			}
+		})
+		if strings.Contains(logged, "reappeared") {
+			t.Errorf("run %d: closed canteen reported as reappeared", i)
+		}
+		if ids := readIds(t, cfg.OutputDir, idsCurFile); !equalIds(ids, []string{"1"}) {
+			t.Errorf("run %d: ids_current %v", i, ids)
+		}
+		if ids := readIds(t, cfg.OutputDir, idsArchiveFile); !equalIds(ids, []string{"2"}) {
+			t.Errorf("run %d: ids_archive %v", i, ids)
+		}
+	}
+
+	site.set(func() { delete(site.info, "2") })
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if ids := readIds(t, cfg.OutputDir, idsCurFile); !equalIds(ids, []string{"1", "2"}) {
+		t.Errorf("after reopening: ids_current %v", ids)
+	}
+	if ids := readIds(t, cfg.OutputDir, idsArchiveFile); len(ids) != 0 {
+		t.Errorf("after reopening: ids_archive %v", ids)
+	}
+}
+
+// TestEventsAcrossRuns checks that consecutive runs log every transition
+// exactly once, closed canteens included
+func TestEventsAcrossRuns(t *testing.T) {
+	site := newTestSite(t, "1", "2", "3")
+	site.info["3"] = testClosure
+	cfg := site.config(t)
+	cfg.ExcludeClosed = true
+	cfg.EventLog = filepath.Join(t.TempDir(), "events.jsonl")
+
+	runs := []struct {
+		ids  []string
+		want []string // events appended by the run
+	}{
+		{[]string{"1", "2", "3"}, []string{"new 1", "new 2", "new 3", "archived 3"}},
+		{[]string{"1", "3"}, []string{"archived 2"}},
+		{[]string{"1", "3"}, nil},
+		{[]string{"1", "2", "3"}, []string{"reappeared 2"}},
+		{[]string{"1", "2", "3"}, nil},
+	}
+	var seen int
+	for i, r := range runs {
+		site.set(func() { site.ids = r.ids })
+		if err := run(cfg); err != nil {
+			t.Fatal(err)
+		}
+		events := readEvents(t, cfg.EventLog)
+		if got := strings.Join(events[seen:], ", "); got != strings.Join(r.want, ", ") {
+			t.Errorf("run %d: events %q, want %q", i+1, got, strings.Join(r.want, ", "))
+		}
+		seen = len(events)
+	}
+}
+
+// TestSinceIdAfterAbort checks that canteens missed by an aborted run or
+// failing in a run are still new to the next -since-id run
+func TestSinceIdAfterAbort(t *testing.T) {
+	site := newTestSite(t, "1", "2", "3")
+	cfg := site.config(t)
+	cfg.SinceID = true
+	cfg.EventLog = filepath.Join(t.TempDir(), "events.jsonl")
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	// the listing and the metadata of 4 use up the cap
+	site.set(func() { site.ids = []string{"1", "2", "3", "4", "5"} })
+	capped := cfg
+	capped.MaxRequests = 2
+	if err := run(capped); err == nil {
+		t.Fatal("capped run succeeded")
+	}
+	if mark := readIds(t, cfg.OutputDir, idsMarkFile); !equalIds(mark, []string{"3"}) {
+		t.Errorf("mark after the aborted run: %v", mark)
+	}
+	if ids := readIds(t, cfg.OutputDir, idsAllFile); !equalIds(ids, []string{"1", "2", "3"}) {
+		t.Errorf("ids_all after the aborted run: %v", ids)
+	}
+
+	site.set(func() { site.status["5"] = http.StatusInternalServerError })
+	if err := run(cfg); err == nil {
+		t.Fatal("run with a failing canteen succeeded")
+	}
+	if mark := readIds(t, cfg.OutputDir, idsMarkFile); !equalIds(mark, []string{"4"}) {
+		t.Errorf("mark after the failing canteen: %v", mark)
+	}
+
+	site.set(func() { delete(site.status, "5") })
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if mark := readIds(t, cfg.OutputDir, idsMarkFile); !equalIds(mark, []string{"5"}) {
+		t.Errorf("mark after the last run: %v", mark)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.OutputDir, "5", metadataFile)); err != nil {
+		t.Errorf("canteen 5 was never processed: %s", err)
+	}
+	want := []string{"new 1", "new 2", "new 3", "new 4", "new 5"}
+	if events := readEvents(t, cfg.EventLog); strings.Join(events, ", ") != strings.Join(want, ", ") {
+		t.Errorf("events %v, want %v", events, want)
+	}
+}
+
+// TestCatalogKeepsEntries checks that canteens not processed by a -since-id
+// run or whose metadata failed keep their entry in catalog.json
+func TestCatalogKeepsEntries(t *testing.T) {
+	site := newTestSite(t, "1", "2")
+	cfg := site.config(t)
+	cfg.Catalog = true
+	cfg.SinceID = true
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	catalog := func() []string {
+		var entries []catalogEntry
+		readJSON(t, filepath.Join(cfg.OutputDir, catalogFile), &entries)
+		var ids []string
+		for _, e := range entries {
+			if e.Name == "" || e.Metadata == "" {
+				t.Errorf("incomplete entry %+v", e)
+			}
+			ids = append(ids, e.Id)
+		}
+		return ids
+	}
+
+	site.set(func() { site.ids = []string{"1", "2", "3"} })
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if ids := catalog(); !equalIds(ids, []string{"1", "2", "3"}) {
+		t.Errorf("catalog after the -since-id run: %v", ids)
+	}
+
+	site.set(func() {
+		site.ids = []string{"1", "2", "3", "4"}
+		site.status["2"] = http.StatusInternalServerError
+	})
+	cfg.SinceID = false
+	if err := run(cfg); err == nil {
+		t.Fatal("run with a failing canteen succeeded")
+	}
+	if ids := catalog(); !equalIds(ids, []string{"1", "2", "3", "4"}) {
+		t.Errorf("catalog after the failing canteen: %v", ids)
+	}
+}
+
+// dayPage returns a day page with n meals in categories of four, each with
+// icons, text notes and three prices like the pages of the site
+func dayPage(n int) string {
+	var page strings.Builder
+	page.WriteString("<html><body>\n")
+	for i := 0; i < n; i++ {
+		if i%4 == 0 {
+			if i > 0 {
+				page.WriteString("</div>\n")
+			}
+			fmt.Fprintf(&page, `<div class="splGroupWrapper"><div class="splGroup">Kategorie %d</div>`+"\n", i/4)
+		}
+		fmt.Fprintf(&page, `<div class="splMeal"><span class="bold">Gericht %d<sup>1,a</sup></span>`+
+			`<img class="splIcon" src="/vendor/infomax/mensen/icons/ampel_gruen_70x65.png">`+
+			`<img class="splIcon" src="/vendor/infomax/mensen/icons/15.png">`+
+			`<div class="kennz"><table><tr><td>vegan</td><td class="text-right">1</td></tr></table></div>`+
+			`<div class="text-right">€ 1,95/3,10/4,65</div></div>`+"\n", i)
+	}
+	page.WriteString("</div>\n<p>Stand: 14.10.2026</p>\n</body></html>")
+	return page.String()
+}
+
+// parseDoc parses the page s
+func parseDoc(tb testing.TB, s string) *goquery.Document {
+	tb.Helper()
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(s))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return doc
+}
+
+func BenchmarkParseDay(b *testing.B) {
+	p := NewParser(defaultConfig())
+	doc := parseDoc(b, dayPage(20))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p.parseDay("1", "2026-10-14", doc)
+	}
+}
+
+// mealAllocs is the budget of allocations parsing a meal takes, almost all of
+// them within goquery; compiling a regexp or building a map per meal exceeds
+// it
+const mealAllocs = 215
+
+// raceEnabled is set by the race detector, which allocates on its own
+var raceEnabled bool
+
+// TestParseDayAllocs keeps the work of parseDay per meal within mealAllocs
+func TestParseDayAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("allocations differ under the race detector")
+	}
+	p := NewParser(defaultConfig())
+	small, large := parseDoc(t, dayPage(4)), parseDoc(t, dayPage(24))
+	allocs := func(doc *goquery.Document) float64 {
+		return testing.AllocsPerRun(20, func() { p.parseDay("1", "2026-10-14", doc) })
+	}
+	perMeal := (allocs(large) - allocs(small)) / 20
+	if perMeal > mealAllocs {
+		t.Errorf("%.1f allocations per meal, want at most %d", perMeal, mealAllocs)
+	}
+}
+
+func BenchmarkParsePrices(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		parsePrices("€ 1,95 / 3,10 / 4,65")
+	}
+}
+
+func BenchmarkMetadata(b *testing.B) {
+	site := newTestSite(b, "1", "2", "3")
+	site.info["1"] = `<div><i class="glyphicon glyphicon-earphone"></i></div><div>Tel. 030 939 39 7000</div>
+<script>var map = new ol.Map({view: new ol.View({center: ol.proj.fromLonLat([ 13.32631, 52.50974 ])})});</script>`
+	p := NewParser(site.config(b))
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := p.Metadata(ctx, "1"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// TestRetryDelaySeeded checks the backoff of a seeded parser: full jitter
+// below the exponentially growing, capped backoff, the same for every parser
+// with the same seed
+func TestRetryDelaySeeded(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.RetryStep, cfg.RetryFactor, cfg.RetryMax = time.Second, 2, 5*time.Second
+	p := NewParser(cfg)
+	p.jitter = newJitter(rand.NewSource(1))
+
+	r := rand.New(rand.NewSource(1))
+	for attempt, backoff := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		want := time.Duration(r.Int63n(int64(backoff)))
+		if d := p.retryDelay(attempt + 1); d != want {
+			t.Errorf("attempt %d: delay %s, want %s", attempt+1, d, want)
+		}
+	}
+
+	cfg.RetryStep = 0
+	if d := NewParser(cfg).retryDelay(1); d != 0 {
+		t.Errorf("delay %s without backoff", d)
+	}
+}
+
+// TestTodayFeed checks that the feeds full and today carry their own
+// schedules and that today.xml holds the day of the run only
+func TestTodayFeed(t *testing.T) {
+	site := newTestSite(t, "1")
+	cfg, err := parseFlags([]string{"-today-feed", "-days-after", "1", "-feed-schedule", "today:hour=7-15;minute=*/30"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := site.config(t)
+	cfg.MetaURL, cfg.MealURL, cfg.DefaultID, cfg.OutputDir, cfg.FeedBase = base.MetaURL, base.MealURL, base.DefaultID, base.OutputDir, base.FeedBase
+	cfg.RetryStep, cfg.RetryMax = base.RetryStep, base.RetryMax
+	cfg.DaysBefore = 0
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	metadata, err := os.ReadFile(filepath.Join(cfg.OutputDir, "1", metadataFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata = regexp.MustCompile(`>\s+<`).ReplaceAll(metadata, []byte("><"))
+	for _, feed := range []string{
+		`<feed name="full"><schedule hour="8" retry="45 3 1440"></schedule><url>https://feeds.example/1/full.xml</url>`,
+		`<feed name="today"><schedule hour="7-15" minute="*/30"></schedule><url>https://feeds.example/1/today.xml</url>`,
+	} {
+		if !bytes.Contains(metadata, []byte(feed)) {
+			t.Errorf("metadata lacks %s:\n%s", feed, metadata)
+		}
+	}
+
+	full, err := os.ReadFile(filepath.Join(cfg.OutputDir, "1", fullFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	today, err := os.ReadFile(filepath.Join(cfg.OutputDir, "1", todayFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	date := time.Now().Format("2006-01-02")
+	if n := bytes.Count(full, []byte("<day ")); n != 2 {
+		t.Errorf("full feed with %d days", n)
+	}
+	if n := bytes.Count(today, []byte("<day ")); n != 1 || !bytes.Contains(today, []byte(`<day date="`+date+`">`)) {
+		t.Errorf("today feed is not the day %s:\n%s", date, today)
+	}
+
+	if _, err := parseFlags([]string{"-today-feed", "-days-before", "1", "-days-after", "3"}); err == nil {
+		t.Error("-today-feed accepted a window without today")
+	}
+}
+
+// TestNotifyChanged checks that only feeds whose content changed are
+// notified about, the -stamp comment aside
+func TestNotifyChanged(t *testing.T) {
+	var mu sync.Mutex
+	var notified []string
+	recorder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n struct{ Id, Url string }
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			t.Errorf("notification: %s", err)
+		}
+		mu.Lock()
+		notified = append(notified, n.Id+" "+n.Url)
+		mu.Unlock()
+	}))
+	defer recorder.Close()
+	notifications := func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		s := strings.Join(notified, ", ")
+		notified = nil
+		return s
+	}
+
+	site := newTestSite(t, "1", "2")
+	cfg := site.config(t)
+	cfg.NotifyURL = recorder.URL
+	cfg.Stamp = true
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if n := notifications(); n != "1 https://feeds.example/1/full.xml, 2 https://feeds.example/2/full.xml" {
+		t.Errorf("first run notified %q", n)
+	}
+
+	// the stamp of the next run differs by a second at least
+	time.Sleep(time.Second)
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if n := notifications(); n != "" {
+		t.Errorf("unchanged run notified %q", n)
+	}
+
+	cfg.IncludeIds = []string{"2"}
+	site.set(func() { site.day = strings.Replace(testDay, "Schnitzel", "Bratwurst", 1) })
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if n := notifications(); n != "2 https://feeds.example/2/full.xml" {
+		t.Errorf("changed run notified %q", n)
+	}
+}
+
+// testInfo is additional metadata page HTML with all the details the catalog
+// takes beyond the feed
+const testInfo = `<div><i class="glyphicon glyphicon-earphone"></i></div><div>Tel. 030 939 39 7000
+Fax 030 939 39 7001</div>
+<div class="alert alert-info">Heute geöffnet bis 15:00 Uhr</div>
+<div><i class="glyphicon glyphicon-info-sign"></i></div><div>
+<p>Die Mensa ist barrierefrei.</p>
+<p>Verkehrsanbindung: U2 Ernst-Reuter-Platz, Bus 245</p>
+<p>Betreiber: Beispiel Catering GmbH</p>
+<p>350 Sitzplätze</p>
+<p>Öffnungszeiten abweichend in den Semesterferien (15.07.2026 – 14.10.2026): Mo. – Fr. 11:00 – 14:00 Uhr</p>
+</div>`
+
+// TestCatalogDetails checks the details of a canteen in catalog.json and
+// that the "Stand" date of its meal pages survives a run without it
+func TestCatalogDetails(t *testing.T) {
+	site := newTestSite(t, "1")
+	site.info["1"] = testInfo
+	site.day = dayPage(1)
+	cfg := site.config(t)
+	cfg.Catalog = true
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	date := time.Now().Format("2006-01-02")
+	want := catalogEntry{
+		Id:            "1",
+		Name:          "Mensa 1",
+		Address:       "Hardenbergstr. 34, 10623 Berlin",
+		City:          "Berlin",
+		District:      "Charlottenburg",
+		Phone:         "030 939 39 7000",
+		Fax:           "030 939 39 7001",
+		SemesterBreak: &SemesterBreak{Note: "Öffnungszeiten abweichend in den Semesterferien (15.07.2026 – 14.10.2026): Mo. – Fr. 11:00 – 14:00 Uhr", From: "2026-07-15", To: "2026-10-14"},
+		Accessibility: "barrierefrei",
+		Transit:       []string{"U2 Ernst-Reuter-Platz", "Bus 245"},
+		Operator:      "Beispiel Catering GmbH",
+		Seats:         350,
+		Status:        &catalogStatus{Date: date, Status: "Heute geöffnet bis 15:00 Uhr"},
+		Updated:       "2026-10-14",
+		Metadata:      "https://feeds.example/1/metadata.xml",
+	}
+	check := func(when string) {
+		t.Helper()
+		var entries []catalogEntry
+		readJSON(t, filepath.Join(cfg.OutputDir, catalogFile), &entries)
+		if len(entries) != 1 {
+			t.Fatalf("%s: %d entries", when, len(entries))
+		}
+		e := entries[0]
+		e.Hours = nil
+		got, _ := json.Marshal(e)
+		wanted, _ := json.Marshal(want)
+		if !bytes.Equal(got, wanted) {
+			t.Errorf("%s: entry\n%s\nwant\n%s", when, got, wanted)
+		}
+	}
+	check("first run")
+
+	site.set(func() { site.day = testDay })
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	check("run without a Stand date")
+}
+
+// TestImageNotes checks that the dish photo, lazy-loaded or not, becomes a
+// note with -image-notes only
+func TestImageNotes(t *testing.T) {
+	doc := parseDoc(t, `<html><body>
+<div class="splGroupWrapper"><div class="splGroup">Essen</div>
+<div class="splMeal"><img src="data:image/gif;base64,R0lGOD" data-src="/fotos/schnitzel.jpg"><span class="bold">Schnitzel</span><div class="text-right">€ 1,95/3,10/4,65</div></div>
+<div class="splMeal"><img src="https://img.example/suppe.jpg"><span class="bold">Suppe</span><div class="text-right">€ 0,95/1,10/1,65</div></div>
+<div class="splMeal"><span class="bold">Salat</span><div class="text-right">€ 0,65/0,90/1,20</div></div>
+</div>
+</body></html>`)
+	cfg := defaultConfig()
+	cfg.MealURL = "https://www.stw.berlin/xhr/speiseplan-wochentag.html"
+	want := [][]Note{{"https://www.stw.berlin/fotos/schnitzel.jpg"}, {"https://img.example/suppe.jpg"}, nil}
+
+	for _, images := range []bool{false, true} {
+		cfg.ImageNotes = images
+		d := NewParser(cfg).parseDay("1", "2026-10-14", doc)
+		meals := d.Categories[0].Meals
+		if len(meals) != len(want) {
+			t.Fatalf("%d meals", len(meals))
+		}
+		for i, m := range meals {
+			var notes []Note
+			if images {
+				notes = want[i]
+			}
+			if fmt.Sprint(m.Notes) != fmt.Sprint(notes) {
+				t.Errorf("-image-notes=%v: %s: notes %v, want %v", images, m.Name, m.Notes, notes)
+			}
+		}
+	}
+}
+
+func TestParseSeats(t *testing.T) {
+	for _, test := range []struct {
+		text  string
+		seats int
+		err   bool
+	}{
+		{"", 0, false},
+		{"350 Sitzplätze", 350, false},
+		{"Die Mensa hat 350 Sitzplaetze.", 350, false},
+		{"Sitzplätze: ca. 1.200", 1200, false},
+		{"Öffnungszeiten Mo 11:30 Sitzplätze: 200", 200, false},
+		{"Mo 11:30 Sitzplätze", 0, true},
+		{"350 überdachte Sitzplätze", 0, true},
+		{"Sitzplätze im Freien", 0, true},
+	} {
+		seats, err := parseSeats(test.text)
+		if seats != test.seats || (err != nil) != test.err {
+			t.Errorf("parseSeats(%q) = %d, %v", test.text, seats, err)
+		}
+	}
+}
+
+// TestSeatsInfoBlock checks that only the info block of a canteen page tells
+// its capacity
+func TestSeatsInfoBlock(t *testing.T) {
+	site := newTestSite(t, "1", "2")
+	site.info["1"] = `<p>Die Cafeteria nebenan hat 80 Sitzplätze.</p>`
+	site.info["2"] = `<p>Die Cafeteria nebenan hat 80 Sitzplätze.</p>
+<div><i class="glyphicon glyphicon-info-sign"></i></div><div><p>350 Sitzplätze</p></div>`
+	p := NewParser(site.config(t))
+	for id, want := range map[string]int{"1": 0, "2": 350} {
+		c, err := p.Metadata(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Seats != want {
+			t.Errorf("%s: %d seats, want %d", id, c.Seats, want)
+		}
+	}
+}
+
+// TestBannersOnly checks that the status and closure of a canteen are taken
+// from its banners, not from other text of the page
+func TestBannersOnly(t *testing.T) {
+	site := newTestSite(t, "1", "2")
+	site.info["1"] = `<p>Die Mensa Nord ist bis auf Weiteres geschlossen, heute geschlossen ist die Cafeteria.</p>`
+	site.info["2"] = `<p>Die Mensa Nord ist bis auf Weiteres geschlossen.</p>
+<div class="alert alert-warning">Heute geschlossen</div>` + testClosure
+	p := NewParser(site.config(t))
+	for id, want := range map[string][2]string{
+		"1": {"", ""},
+		"2": {"Heute geschlossen", "bis auf Weiteres geschlossen"},
+	} {
+		c, err := p.Metadata(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.TodayStatus != want[0] || c.Closure != want[1] {
+			t.Errorf("%s: status %q and closure %q, want %q", id, c.TodayStatus, c.Closure, want)
+		}
+	}
+}
+
+func TestParsePhone(t *testing.T) {
+	for _, test := range []struct{ text, phone, fax string }{
+		{"", "", ""},
+		{"Telefon 030 123", "030 123", ""},
+		{"030 123 / Fax 030 456", "030 123", "030 456"},
+		{"Tel. 030 123\nFax: 030 456", "030 123", "030 456"},
+		{"Tel.: 030 123Fax 030 456", "030 123", "030 456"},
+		{"Tel. 030 123, Telefax 030 456", "030 123", "030 456"},
+		{"Fax 030 456\nTel. 030 123", "030 123", "030 456"},
+		{"Fax 030 456", "", "030 456"},
+	} {
+		if phone, fax := parsePhone(test.text); phone != test.phone || fax != test.fax {
+			t.Errorf("parsePhone(%q) = %q, %q", test.text, phone, fax)
+		}
+	}
+}
+
+func TestCollectSources(t *testing.T) {
+	doc := parseDoc(t, `<html><body>
+<div id="directlink">/mensen/mensa-hardenbergstrasse.html</div>
+<a href="speiseplan/hardenberg.html">Speiseplan</a>
+<a href="https://www.stw.berlin/mensen/mensa-hardenbergstrasse.html?speiseplan">Speiseplan</a>
+<a href="/mensen/mensa-hardenbergstrasse.html#speiseplan">Speiseplan</a>
+<a href="javascript:speiseplan()">Speiseplan</a>
+</body></html>`)
+	want := []string{
+		"https://www.stw.berlin/mensen/mensa-hardenbergstrasse.html",
+		"https://www.stw.berlin/xhr/speiseplan/hardenberg.html",
+		"https://www.stw.berlin/mensen/mensa-hardenbergstrasse.html?speiseplan",
+		"https://www.stw.berlin/mensen/mensa-hardenbergstrasse.html#speiseplan",
+	}
+	sources := collectSources(doc, "https://www.stw.berlin/xhr/hinweise.html")
+	if strings.Join(sources, " ") != strings.Join(want, " ") {
+		t.Errorf("sources %v, want %v", sources, want)
+	}
+}
+
+// throttlingSite answers every request with status 509
+func throttlingSite(t *testing.T) *httptest.Server {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(509)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// TestNoWaitAfterLastAttempt checks that a fetch gives up right after its
+// last attempt instead of backing off for an attempt that never comes
+func TestNoWaitAfterLastAttempt(t *testing.T) {
+	site := throttlingSite(t)
+	cfg := defaultConfig()
+	cfg.RetryStep, cfg.RetryMax = 200*time.Millisecond, 200*time.Millisecond
+	p := NewParser(cfg)
+	p.jitter = func(n int64) int64 { return n - 1 }
+
+	start := time.Now()
+	if _, err := p.getHttpDocRetries(context.Background(), site.URL, nil, 1); err == nil {
+		t.Fatal("fetch succeeded")
+	}
+	if d := time.Since(start); d >= 200*time.Millisecond {
+		t.Errorf("single attempt took %s", d)
+	}
+}
+
+// TestAttemptContextReleased checks that the context of a failed attempt is
+// released before the next attempt, not only when the fetch returns
+func TestAttemptContextReleased(t *testing.T) {
+	site := throttlingSite(t)
+	p := NewParser(defaultConfig())
+	p.cfg.RetryStep, p.cfg.RetryMax = time.Millisecond, time.Millisecond
+	p.jitter = func(n int64) int64 { return n - 1 }
+	// without a client timeout, the transport sees the attempt's own context
+	p.client.Timeout = 0
+	var ctxs []context.Context
+	transport := p.client.Transport
+	p.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		for i, ctx := range ctxs {
+			if ctx.Err() == nil {
+				t.Errorf("attempt %d: context of attempt %d still live", len(ctxs)+1, i+1)
+			}
+		}
+		ctxs = append(ctxs, r.Context())
+		return transport.RoundTrip(r)
+	})
+
+	if _, err := p.getHttpDocRetries(context.Background(), site.URL, nil, 3); err == nil {
+		t.Fatal("fetch succeeded")
+	}
+	if len(ctxs) != 3 {
+		t.Errorf("%d attempts, want 3", len(ctxs))
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+// TestRetryCounters checks the retries, 509 responses and backoff counted for
+// sequences of responses; the failed last attempt of a fetch is no retry
+func TestRetryCounters(t *testing.T) {
+	for _, test := range []struct {
+		statuses           []int
+		retries, throttled int64
+	}{
+		{[]int{200}, 0, 0},
+		{[]int{509, 509, 200}, 2, 2},
+		{[]int{509, 509, 509}, 2, 2},
+		{[]int{509, 404}, 1, 1},
+	} {
+		var mu sync.Mutex
+		statuses := test.statuses
+		site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			status := statuses[0]
+			statuses = statuses[1:]
+			mu.Unlock()
+			w.WriteHeader(status)
+			fmt.Fprint(w, "<html></html>")
+		}))
+		p := NewParser(defaultConfig())
+		p.cfg.RetryStep, p.cfg.RetryMax = time.Millisecond, time.Millisecond
+		p.jitter = func(n int64) int64 { return n - 1 }
+		p.getHttpDocRetries(context.Background(), site.URL, nil, len(test.statuses))
+		site.Close()
+
+		backoff := time.Duration(test.retries) * (time.Millisecond - 1)
+		if p.retries != test.retries || p.throttled != test.throttled || time.Duration(p.backoff) != backoff {
+			t.Errorf("%v: %d retries, %d throttled, %s backoff, want %d, %d, %s",
+				test.statuses, p.retries, p.throttled, time.Duration(p.backoff), test.retries, test.throttled, backoff)
+		}
+	}
+}
+
+func TestThrottleDelay(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.RetryStep, cfg.RetryMax = time.Second, time.Minute
+	p := NewParser(cfg)
+	p.jitter = func(n int64) int64 { return n - 1 }
+
+	ctx := context.Background()
+	soon, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	for _, test := range []struct {
+		ctx        context.Context
+		retryAfter string
+		min, max   time.Duration
+	}{
+		{ctx, "", time.Second - 1, time.Second - 1},
+		{ctx, "soon", time.Second - 1, time.Second - 1},
+		{ctx, "30", 30 * time.Second, 30 * time.Second},
+		{ctx, "3600", time.Minute, time.Minute},
+		{ctx, time.Now().Add(time.Hour).UTC().Format(http.TimeFormat), time.Minute, time.Minute},
+		{soon, "30", 9 * time.Second, 10 * time.Second},
+	} {
+		if d := p.throttleDelay(test.ctx, 1, test.retryAfter); d < test.min || d > test.max {
+			t.Errorf("Retry-After %q: delay %s, want %s to %s", test.retryAfter, d, test.min, test.max)
+		}
+	}
+}
+
+// TestKeepUnchangedFeed checks that a feed whose pages are all answered 304
+// Not Modified is kept as written before, and only then
+func TestKeepUnchangedFeed(t *testing.T) {
+	site := newTestSite(t, "1")
+	site.etag = true
+	cfg := site.config(t)
+	cfg.DaysAfter = 2
+	cfg.HTTPCache = t.TempDir()
+	feed := filepath.Join(cfg.OutputDir, "1", fullFile)
+
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	// a feed that would not be written like this again
+	data, err := os.ReadFile(feed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept := append(data, "<!-- kept -->\n"...)
+	if err := os.WriteFile(feed, kept, 0644); err != nil {
+		t.Fatal(err)
+	}
+	read := func() string {
+		t.Helper()
+		data, err := os.ReadFile(feed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if read() != string(kept) {
+		t.Error("unchanged feed was written anew")
+	}
+
+	site.set(func() { site.day = strings.Replace(testDay, "Schnitzel", "Bratwurst", 1) })
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if f := read(); strings.Contains(f, "kept") || !strings.Contains(f, "Bratwurst") {
+		t.Errorf("changed feed was kept:\n%s", f)
+	}
+
+	// without the feed written before there is nothing to keep
+	if err := os.Remove(feed); err != nil {
+		t.Fatal(err)
+	}
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if f := read(); !strings.Contains(f, "Bratwurst") {
+		t.Errorf("missing feed was not written anew:\n%s", f)
+	}
+}
+
+// TestMensaToGoName checks the name taken from the mensatogo iframe; a name
+// that does not decode fails the canteen, not the process
+func TestMensaToGoName(t *testing.T) {
+	var site *httptest.Server
+	site = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/meta":
+			fmt.Fprintf(w, `<html><body><iframe src="%s/togo?mensa=%s"></iframe></body></html>`, site.URL, r.FormValue("resources_id"))
+		case "/togo":
+			fmt.Fprint(w, `<html><body><script>var locations = JSON.parse('{"7":"Mensa Nord ä","8":"Mensa \q"}');</script></body></html>`)
+		}
+	}))
+	defer site.Close()
+	cfg := defaultConfig()
+	cfg.MetaURL = site.URL + "/meta"
+	p := NewParser(cfg)
+
+	c, err := p.Metadata(context.Background(), "7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Name != "Mensa Nord ä" {
+		t.Errorf("name %q", c.Name)
+	}
+	if _, err := p.Metadata(context.Background(), "8"); err == nil {
+		t.Error("undecodable name accepted")
+	}
+}
+
+// readArchive returns the files of the .tar.gz filename by name
+func readArchive(t *testing.T, filename string) map[string]string {
+	t.Helper()
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[hdr.Name] = string(data)
+	}
+}
+
+// TestArchiveState checks that runs into an archive carry their state over
+// through the output directory, and only once the archive is published
+func TestArchiveState(t *testing.T) {
+	site := newTestSite(t, "1", "2")
+	cfg := site.config(t)
+	cfg.Archive = filepath.Join(t.TempDir(), "out.tar.gz")
+	cfg.EventLog = filepath.Join(t.TempDir(), "events.jsonl")
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.OutputDir, "1")); !os.IsNotExist(err) {
+		t.Errorf("feeds written to the output directory: %v", err)
+	}
+
+	site.set(func() { site.ids = []string{"1", "3"} })
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	files := readArchive(t, cfg.Archive)
+	if ids := files[idsArchiveFile]; ids != "2\n" {
+		t.Errorf("archived ids %q", ids)
+	}
+	if _, ok := files["3/"+fullFile]; !ok {
+		t.Error("archive lacks the feed of 3")
+	}
+	if ids := readIds(t, cfg.OutputDir, idsAllFile); !equalIds(ids, []string{"1", "2", "3"}) {
+		t.Errorf("ids_all %v", ids)
+	}
+	want := []string{"new 1", "new 2", "new 3", "archived 2"}
+	if events := readEvents(t, cfg.EventLog); strings.Join(events, ", ") != strings.Join(want, ", ") {
+		t.Errorf("events %v, want %v", events, want)
+	}
+
+	// an aborted run publishes neither archive nor state
+	site.set(func() { site.ids = []string{"1", "3", "4"} })
+	capped := cfg
+	capped.MaxRequests = 2
+	if err := run(capped); err == nil {
+		t.Fatal("capped run succeeded")
+	}
+	if ids := readIds(t, cfg.OutputDir, idsAllFile); !equalIds(ids, []string{"1", "2", "3"}) {
+		t.Errorf("ids_all after the aborted run %v", ids)
+	}
+	if _, ok := readArchive(t, cfg.Archive)["4/"+metadataFile]; ok {
+		t.Error("aborted run replaced the archive")
+	}
+
+	if _, err := parseFlags([]string{"-archive", cfg.Archive, "-notify-url", "https://hooks.example/"}); err == nil {
+		t.Error("-notify-url accepted with -archive")
+	}
+}
+
+// TestArchiveStdout checks that stdout only gets complete archives
+func TestArchiveStdout(t *testing.T) {
+	stdout := os.Stdout
+	defer func() { os.Stdout = stdout }()
+	for _, commit := range []bool{false, true} {
+		f, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.Stdout = f
+		a, err := openArchive("-", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := a.Write("index.json", strings.NewReader("{}\n")); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.close(commit); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		os.Stdout = stdout
+
+		if commit {
+			if files := readArchive(t, f.Name()); files["index.json"] != "{}\n" {
+				t.Errorf("archive on stdout: %v", files)
+			}
+		} else if info, err := os.Stat(f.Name()); err != nil {
+			t.Fatal(err)
+		} else if info.Size() != 0 {
+			t.Errorf("discarded archive of %d bytes written to stdout", info.Size())
+		}
+	}
+}
+
+// TestTraceOTLP checks that a run sends its spans to an OTLP/HTTP collector
+func TestTraceOTLP(t *testing.T) {
+	var mu sync.Mutex
+	var spans []otlpSpan
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var traces otlpTraces
+		if err := json.NewDecoder(r.Body).Decode(&traces); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, rs := range traces.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+	}))
+	defer collector.Close()
+
+	site := newTestSite(t, "1")
+	cfg := site.config(t)
+	cfg.TraceOTLP = collector.URL + "/v1/traces"
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	names := make(map[string]int)
+	var root string
+	for _, s := range spans {
+		names[s.Name]++
+		if s.Name == "run" {
+			root = s.SpanId
+		}
+	}
+	for _, name := range []string{"run", "fetchIds", "metadata", "meals", "day", "write"} {
+		if names[name] == 0 {
+			t.Errorf("no %s span in %v", name, names)
+		}
+	}
+	for _, s := range spans {
+		if s.Name != "run" && s.ParentSpanId != root {
+			t.Errorf("%s span is no child of the run", s.Name)
+		}
+	}
+}
+
+// testWeek is a multi-day view labeling its days relatively, by weekday and
+// by date
+const testWeek = `<html><body>
+<div class="splDayHeader">Heute</div>
+<div class="splGroupWrapper"><div class="splGroup">Essen</div>
+<div class="splMeal"><span class="bold">Schnitzel</span><div class="text-right">€ 1,95/3,10/4,65</div></div>
+</div>
+<div class="splDayHeader">Morgen</div>
+<div class="splGroupWrapper"><div class="splGroup">Essen</div>
+<div class="splMeal"><span class="bold">Suppe</span><div class="text-right">€ 0,95/1,10/1,65</div></div>
+</div>
+<div class="splDayHeader">Freitag</div>
+<div class="splGroupWrapper"><div class="splGroup">Aktionen</div>
+<div class="splMeal"><span class="bold">Fisch</span><div class="text-right">€ 2,95/4,10/5,65</div></div>
+</div>
+<div class="splDayHeader">Montag, 19.10.</div>
+<div class="splGroupWrapper"><div class="splGroup">Essen</div>
+<div class="splMeal"><span class="bold">Eintopf</span><div class="text-right">€ 1,45/2,10/3,65</div></div>
+</div>
+</body></html>`
+
+func TestResolveDayLabel(t *testing.T) {
+	// a Wednesday
+	anchor := time.Date(2026, 10, 14, 15, 0, 0, 0, time.Local)
+	for _, test := range []struct {
+		label, date string
+	}{
+		{"Heute", "2026-10-14"},
+		{"morgen", "2026-10-15"},
+		{"Übermorgen", "2026-10-16"},
+		{"gestern", "2026-10-13"},
+		{"Mittwoch", "2026-10-14"},
+		{"Fr.", "2026-10-16"},
+		{"Sonnabend", "2026-10-17"},
+		{"Dienstag", "2026-10-20"},
+		{"Montag, 19.10.", "2026-10-19"},
+		{"2.1.", "2027-01-02"},
+		{"31.12.25", "2025-12-31"},
+		{"31.2.", ""},
+		{"Speiseplan", ""},
+	} {
+		date, ok := resolveDayLabel(test.label, anchor)
+		if date != test.date || ok != (test.date != "") {
+			t.Errorf("%s: %q %v, want %q", test.label, date, ok, test.date)
+		}
+	}
+}
+
+// TestMultiDayPage checks that the days of a multi-day view get the dates
+// of their headers, and that a day page picks its own section of one
+func TestMultiDayPage(t *testing.T) {
+	p := NewParser(defaultConfig())
+	p.anchor = time.Date(2026, 10, 14, 15, 0, 0, 0, time.Local)
+	doc := parseDoc(t, testWeek)
+
+	want := map[string]string{"2026-10-14": "Schnitzel", "2026-10-15": "Suppe", "2026-10-16": "Fisch", "2026-10-19": "Eintopf"}
+	days := p.parseDays("1", doc)
+	if len(days) != len(want) {
+		t.Fatalf("%d days, want %d", len(days), len(want))
+	}
+	for _, d := range days {
+		if len(d.Categories) != 1 || len(d.Categories[0].Meals) != 1 || d.Categories[0].Meals[0].Name != want[d.Date] {
+			t.Errorf("%s: %+v", d.Date, d.Categories)
+		}
+	}
+
+	d, err := p.dayOf("1", "2026-10-16", doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Categories) != 1 || d.Categories[0].Name != "Aktionen" {
+		t.Errorf("day of a multi-day page: %+v", d)
+	}
+	if _, err := p.dayOf("1", "2026-10-17", doc); err == nil {
+		t.Error("day missing from the multi-day page was not reported")
+	}
+}
+
+// TestInterruptedRun checks that a run cancelled while fetching a canteen
+// keeps the canteens written before and leaves no partial file behind
+func TestInterruptedRun(t *testing.T) {
+	site := newTestSite(t, "1", "2")
+	site.hang["2"] = true
+	cfg := site.config(t)
+	cfg.Concurrency = 1
+	cfg.RunTimeout = 300 * time.Millisecond
+	if err := run(cfg); err == nil {
+		t.Fatal("interrupted run succeeded")
+	}
+
+	if _, err := os.Stat(filepath.Join(cfg.OutputDir, "1", fullFile)); err != nil {
+		t.Errorf("canteen fetched before the interruption: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.OutputDir, "2", fullFile)); err == nil {
+		t.Error("interrupted canteen was written")
+	}
+	err := filepath.Walk(cfg.OutputDir, func(path string, fi os.FileInfo, err error) error {
+		if err == nil && strings.HasPrefix(fi.Name(), ".") {
+			t.Errorf("temporary file %s left", path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestDefaultFileMode checks that without -output-permissions new files get
+// the mode os.Create gives them, and existing files keep theirs
+func TestDefaultFileMode(t *testing.T) {
+	dir := t.TempDir()
+	f, err := os.Create(filepath.Join(dir, "created"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	created, err := os.Stat(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name := filepath.Join(dir, "written")
+	if err := writeFileAtomic(name, []byte("1"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(name); err != nil || fi.Mode() != created.Mode() {
+		t.Errorf("new file: %v %v, want %v", fi.Mode(), err, created.Mode())
+	}
+	if err := os.Chmod(name, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(name, []byte("2"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(name); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("rewritten file: %v %v, want 0600", fi.Mode(), err)
+	}
+}
+
+// TestOutputPermissions checks that -output-permissions applies to the
+// output and to every report, log and state file a run writes
+func TestOutputPermissions(t *testing.T) {
+	site := newTestSite(t, "1")
+	cfg := site.config(t)
+	cfg.OutputMode = 0640
+	reports := t.TempDir()
+	cfg.RequestLog = filepath.Join(reports, "requests.jsonl")
+	cfg.Trace = filepath.Join(reports, "trace.jsonl")
+	cfg.ErrorsReport = filepath.Join(reports, "errors.json")
+	cfg.IconReport = filepath.Join(reports, "icons.json")
+	cfg.EventLog = filepath.Join(reports, "events.jsonl")
+	cfg.Checkpoint = filepath.Join(reports, "checkpoint.json")
+	cfg.FetchDump = filepath.Join(reports, "dump")
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dir := range []string{cfg.OutputDir, reports} {
+		err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+			if err != nil || path == dir {
+				return err
+			}
+			want := os.FileMode(0640)
+			if fi.IsDir() {
+				want = 0750 | os.ModeDir
+			}
+			if fi.Mode() != want {
+				t.Errorf("%s: %v, want %v", path, fi.Mode(), want)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(cfg.FetchDump, "day")); err != nil {
+		t.Errorf("no fetch dump: %s", err)
+	}
+}
+
+// TestCheckpointResume checks that a run interrupted during a backfill is
+// resumed with the canteen it did not finish, and that the checkpoint is
+// removed once a run got through
+func TestCheckpointResume(t *testing.T) {
+	site := newTestSite(t, "1", "2")
+	site.hang["2"] = true
+	cfg := site.config(t)
+	cfg.Concurrency = 1
+	cfg.Checkpoint = filepath.Join(t.TempDir(), "checkpoint.json")
+
+	interrupted := cfg
+	interrupted.RunTimeout = 300 * time.Millisecond
+	if err := run(interrupted); err == nil {
+		t.Fatal("interrupted run succeeded")
+	}
+	var dates map[string]string
+	readJSON(t, cfg.Checkpoint, &dates)
+	if len(dates) != 1 || dates["1"] == "" {
+		t.Errorf("checkpoint after the interruption: %v", dates)
+	}
+
+	var before int
+	site.set(func() {
+		site.hang["2"] = false
+		before = site.requests["/day"]
+	})
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if n := site.requests["/day"] - before; n != 1 {
+		t.Errorf("resumed run fetched %d day pages, want only the one of canteen 2", n)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.OutputDir, "2", fullFile)); err != nil {
+		t.Errorf("resumed canteen: %s", err)
+	}
+	if _, err := os.Stat(cfg.Checkpoint); err == nil {
+		t.Error("checkpoint left after a complete run")
+	}
+}
+
+// testNoMeals is a day page whose only category has no meals
+const testNoMeals = `<html><body>
+<div class="splGroupWrapper"><div class="splGroup">Essen</div></div>
+</body></html>`
+
+// TestRetryEmptyMeals checks that a day without meals on which the canteen
+// is open is fetched again, and only with -retry-on-empty-meals
+func TestRetryEmptyMeals(t *testing.T) {
+	for _, retries := range []int{0, 1} {
+		site := newTestSite(t, "1")
+		site.days = []string{testNoMeals}
+		cfg := site.config(t)
+		cfg.RetryEmptyMeals = retries
+		if err := run(cfg); err != nil {
+			t.Fatal(err)
+		}
+		feed, err := os.ReadFile(filepath.Join(cfg.OutputDir, "1", fullFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := bytes.Contains(feed, []byte("Schnitzel")); got != (retries > 0) {
+			t.Errorf("-retry-on-empty-meals %d: meals of the refetched day in the feed: %v", retries, got)
+		}
+		if n, want := site.requests["/day"], 1+retries; n != want {
+			t.Errorf("-retry-on-empty-meals %d: %d day requests, want %d", retries, n, want)
+		}
+	}
+}
+
+// TestFetchDumpRaw checks that -fetch-dump saves a page served in
+// ISO-8859-1 as it came, not as decoded for parsing
+func TestFetchDumpRaw(t *testing.T) {
+	site := newTestSite(t, "1")
+	latin1 := strings.NewReplacer("Schnitzel", "Schnitzel mit Gem\xfcse", "€", "EUR").Replace(testDay)
+	site.day = latin1
+	cfg := site.config(t)
+	cfg.FetchDump = t.TempDir()
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	dumps, err := filepath.Glob(filepath.Join(cfg.FetchDump, "day", "1_*.html"))
+	if err != nil || len(dumps) != 1 {
+		t.Fatalf("dumps %v %v", dumps, err)
+	}
+	dump, err := os.ReadFile(dumps[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(dump) != latin1 {
+		t.Errorf("dump is not the raw page:\n%q", dump)
+	}
+	feed, err := os.ReadFile(filepath.Join(cfg.OutputDir, "1", fullFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(feed, []byte("Schnitzel mit Gemüse")) {
+		t.Errorf("feed lacks the decoded meal:\n%s", feed)
+	}
+}
+
+// testConsent is a cookie consent page served in place of the site's pages
+const testConsent = `<html><body><h1>Cookie-Einstellungen</h1><button>Alle Cookies akzeptieren</button></body></html>`
+
+// TestConsentWall checks that a consent page in place of a day page fails the
+// canteen at once, without trying the other date formats, and keeps its
+// feed; and that it is detected in place of the metadata page as well
+func TestConsentWall(t *testing.T) {
+	site := newTestSite(t, "1")
+	cfg := site.config(t)
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	feed := filepath.Join(cfg.OutputDir, "1", fullFile)
+	before, err := os.ReadFile(feed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var requests int
+	site.set(func() {
+		site.day = testConsent
+		requests = site.requests["/day"]
+	})
+	if err := run(cfg); err == nil {
+		t.Error("run behind a consent wall succeeded")
+	}
+	if n := site.requests["/day"] - requests; n != 1 {
+		t.Errorf("%d requests of the day page, want 1", n)
+	}
+	if after, err := os.ReadFile(feed); err != nil || !bytes.Equal(after, before) {
+		t.Errorf("feed changed behind the consent wall: %s %v", after, err)
+	}
+
+	wall := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, testConsent)
+	}))
+	defer wall.Close()
+	p := NewParser(site.config(t))
+	p.cfg.MetaURL = wall.URL
+	if _, err := p.Metadata(context.Background(), "1"); err == nil || !strings.Contains(err.Error(), "consent") {
+		t.Errorf("metadata behind a consent wall: %v", err)
+	}
+}
+
+// TestOpeningHoursWeekdays checks that only weekday names start a day range
+// of the opening hours, not other words before them
+func TestOpeningHoursWeekdays(t *testing.T) {
+	site := newTestSite(t, "1")
+	// the regular block of the site says Mo. – So. 11:00 – 14:30
+	site.info["1"] = `<div>Achtung: Sa. – So.
+ 12:00 – 14:00 Uhr</div>`
+	p := NewParser(site.config(t))
+	c, err := p.Metadata(context.Background(), "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"11:00-14:30", "11:00-14:30", "11:00-14:30", "11:00-14:30", "11:00-14:30", "12:00-14:00", "12:00-14:00"}
+	if c.Times == nil || strings.Join(c.Times.openingHours, " ") != strings.Join(want, " ") {
+		t.Errorf("opening hours %+v, want %v", c.Times, want)
+	}
+	if n := p.warnings.len(); n != 0 {
+		t.Errorf("%d warnings", n)
+	}
+
+	if m := reOpeningHours.FindStringSubmatch("Mittag bis\n 15:00 – 16:00 Uhr"); m != nil {
+		t.Errorf("prose taken as day range: %q", m)
+	}
+}
+
+// TestIconNotes checks that icons are told apart by their file name, so
+// 11.png is not taken for 1.png
+func TestIconNotes(t *testing.T) {
+	for src, want := range map[string]string{
+		"/vital/images/1.png":               "vegetarisch",
+		"https://www.stw.berlin/15.png?v=2": "vegan",
+		"/vital/images/ampel_rot_70x65.png": "rot (Ampel)",
+		"/vital/images/11.png":              "",
+		"/vital/images/41.png":              "",
+	} {
+		page := strings.Replace(testDay, `<div class="text-right">`, `<img class="splIcon" src="`+src+`"><div class="text-right">`, 1)
+		p := NewParser(defaultConfig())
+		d := p.parseDay("1", "2026-10-14", parseDoc(t, page))
+		var notes []string
+		for _, n := range d.Categories[0].Meals[0].Notes {
+			notes = append(notes, string(n))
+		}
+		if strings.Join(notes, ", ") != want {
+			t.Errorf("%s: notes %v, want %q", src, notes, want)
+		}
+	}
+}
+
+// indexIds returns the ids of index.json below dir in the order they appear
+func indexIds(t *testing.T, dir string) []string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, indexFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var index map[string]string
+	if err := json.Unmarshal(data, &index); err != nil {
+		t.Fatalf("index.json: %s", err)
+	}
+	var ids []string
+	for _, m := range regexp.MustCompile(`(?m)^\s*("(?:[^"\\]|\\.)*"):`).FindAllSubmatch(data, -1) {
+		var id string
+		if err := json.Unmarshal(m[1], &id); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// TestIdOrder checks that the index, the ids files and the catalog list the
+// canteens in the same order: numeric ids numerically and before the others
+func TestIdOrder(t *testing.T) {
+	site := newTestSite(t, "10", "b", "9", "a", "2", "010")
+	cfg := site.config(t)
+	cfg.Catalog = true
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"2", "9", "010", "10", "a", "b"}
+	if ids := indexIds(t, cfg.OutputDir); !equalIds(ids, want) {
+		t.Errorf("index.json: %v, want %v", ids, want)
+	}
+	for _, name := range []string{idsCurFile, idsAllFile} {
+		if ids := readIds(t, cfg.OutputDir, name); !equalIds(ids, want) {
+			t.Errorf("%s: %v, want %v", name, ids, want)
+		}
+	}
+	var catalog []catalogEntry
+	readJSON(t, filepath.Join(cfg.OutputDir, catalogFile), &catalog)
+	var ids []string
+	for _, e := range catalog {
+		ids = append(ids, e.Id)
+	}
+	if !equalIds(ids, want) {
+		t.Errorf("catalog.json: %v, want %v", ids, want)
+	}
+	if !idsSorted(want) {
+		t.Error("idsSorted rejects the order")
+	}
+}
+
+// TestIdsSpecialCharacters checks that an id with a quote or umlaut keeps
+// index.json valid and the ids files readable, and that ids unusable in
+// either are rejected by both
+func TestIdsSpecialCharacters(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.OutputDir = t.TempDir()
+	p := NewParser(cfg)
+
+	ids := []string{"1", `a"b`, "mensa süd"}
+	if err := p.genIndex(ids); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.saveIds(&ids, idsCurFile); err != nil {
+		t.Fatal(err)
+	}
+	if got := indexIds(t, cfg.OutputDir); !equalIds(got, ids) {
+		t.Errorf("index.json: %v", got)
+	}
+	if got := readIds(t, cfg.OutputDir, idsCurFile); !equalIds(got, ids) {
+		t.Errorf("ids_current: %v", got)
+	}
+
+	for _, id := range []string{"", ".", "..", "a/b", `a\b`, "a\nb", "a\x00b", "\xff"} {
+		if err := checkId(id); err == nil {
+			t.Errorf("%q: accepted", id)
+		}
+		bad := []string{"1", id}
+		if err := p.genIndex(bad); err == nil {
+			t.Errorf("%q: accepted by genIndex", id)
+		}
+		if err := p.saveIds(&bad, idsCurFile); err == nil {
+			t.Errorf("%q: accepted by saveIds", id)
+		}
+	}
+}
+
+// TestOutputLayouts checks the paths of the files of a canteen and the URLs
+// pointing at them for each -output-layout
+func TestOutputLayouts(t *testing.T) {
+	for layout, paths := range map[string][2]string{
+		"nested": {"1/metadata.xml", "1/full.xml"},
+		"flat":   {"1-metadata.xml", "1-full.xml"},
+	} {
+		site := newTestSite(t, "1")
+		cfg := site.config(t)
+		cfg.OutputLayout = layout
+		if err := run(cfg); err != nil {
+			t.Fatal(err)
+		}
+		for _, path := range paths {
+			if _, err := os.Stat(filepath.Join(cfg.OutputDir, filepath.FromSlash(path))); err != nil {
+				t.Errorf("%s: %s", layout, err)
+			}
+		}
+		var index map[string]string
+		readJSON(t, filepath.Join(cfg.OutputDir, indexFile), &index)
+		if want := cfg.FeedBase + paths[0]; index["1"] != want {
+			t.Errorf("%s: index points at %s, want %s", layout, index["1"], want)
+		}
+		metadata, err := os.ReadFile(filepath.Join(cfg.OutputDir, filepath.FromSlash(paths[0])))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "<url>" + cfg.FeedBase + paths[1] + "</url>"; !bytes.Contains(metadata, []byte(want)) {
+			t.Errorf("%s: metadata lacks %s:\n%s", layout, want, metadata)
+		}
+	}
+}
+
+// testTwoCategories is a day page with two categories, one meal with notes
+const testTwoCategories = `<html><body>
+<div class="splGroupWrapper"><div class="splGroup">Essen</div>
+<div class="splMeal"><span class="bold">Schnitzel</span><img class="splIcon" src="/vital/images/15.png"><div class="text-right">€ 1,95/3,10/4,65</div></div>
+</div>
+<div class="splGroupWrapper"><div class="splGroup">Desserts</div>
+<div class="splMeal"><span class="bold">Pudding</span><div class="text-right">€ 0,65</div></div>
+</div>
+</body></html>`
+
+// testGolden is the feed of testTwoCategories on 2026-10-14
+const testGolden = `<?xml version="1.0" encoding="UTF-8"?>
+<openmensa version="2.1"
+           xmlns="http://openmensa.org/open-mensa-v2"
+           xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"
+           xsi:schemaLocation="http://openmensa.org/open-mensa-v2 http://openmensa.org/open-mensa-v2.xsd">
+  <canteen>
+    <day date="2026-10-14">
+      <category name="Essen">
+        <meal>
+          <name>Schnitzel</name>
+          <note>vegan</note>
+          <price role="student">1.95</price>
+          <price role="employee">3.10</price>
+          <price role="other">4.65</price>
+        </meal>
+      </category>
+      <category name="Desserts">
+        <meal>
+          <name>Pudding</name>
+          <price role="other">0.65</price>
+        </meal>
+      </category>
+    </day>
+  </canteen>
+</openmensa>
+`
+
+// TestCompareGolden checks -compare-golden with and without -update: the
+// goldens are written with the nested categories and meals indented right,
+// match on a rerun and fail it once they differ
+func TestCompareGolden(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "1_2026-10-14.html"), []byte(testTwoCategories), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := defaultConfig()
+	cfg.OutputDir = t.TempDir()
+	cfg.GoldenDir = dir
+	cfg.UpdateGolden = true
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	golden := filepath.Join(dir, "1_2026-10-14.xml")
+	if data, err := os.ReadFile(golden); err != nil || string(data) != testGolden {
+		t.Fatalf("golden %v:\n%s", err, data)
+	}
+
+	cfg.UpdateGolden = false
+	if err := run(cfg); err != nil {
+		t.Errorf("rerun against the goldens: %s", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(cfg.OutputDir, "1_2026-10-14.xml")); err != nil || string(data) != testGolden {
+		t.Errorf("output %v:\n%s", err, data)
+	}
+
+	if err := os.WriteFile(golden, []byte(strings.Replace(testGolden, "0.65", "0.75", 1)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := run(cfg); err == nil {
+		t.Error("run against a differing golden succeeded")
+	}
+}
+
+// testMetadataOnly is the metadata of canteen 1 of a testSite with
+// -omit-feeds
+const testMetadataOnly = `<?xml version="1.0" encoding="UTF-8"?>
+<openmensa version="2.1"
+           xmlns="http://openmensa.org/open-mensa-v2"
+           xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"
+           xsi:schemaLocation="http://openmensa.org/open-mensa-v2 http://openmensa.org/open-mensa-v2.xsd">
+  <canteen>
+    <name>Mensa 1</name>
+    <address>Hardenbergstr. 34, 10623 Berlin</address>
+    <city>Berlin</city>
+    <availability>public</availability>
+    <times type="opening">
+      <monday open="11:00-14:30"></monday>
+      <tuesday open="11:00-14:30"></tuesday>
+      <wednesday open="11:00-14:30"></wednesday>
+      <thursday open="11:00-14:30"></thursday>
+      <friday open="11:00-14:30"></friday>
+      <saturday open="11:00-14:30"></saturday>
+      <sunday open="11:00-14:30"></sunday>
+    </times>
+  </canteen>
+</openmensa>
+`
+
+// TestOmitFeeds checks the metadata written with -omit-feeds against its
+// golden and that the feeds are there by default
+func TestOmitFeeds(t *testing.T) {
+	for _, omit := range []bool{true, false} {
+		site := newTestSite(t, "1")
+		cfg := site.config(t)
+		cfg.OmitFeeds = omit
+		if err := run(cfg); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(filepath.Join(cfg.OutputDir, "1", metadataFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if omit && string(data) != testMetadataOnly {
+			t.Errorf("metadata with -omit-feeds:\n%s", data)
+		}
+		if !omit && !bytes.Contains(data, []byte(`<feed name="full">`)) {
+			t.Errorf("default metadata lacks the feed:\n%s", data)
+		}
+	}
+}
+
+// TestTruncatedResponse checks that a day page whose connection drops
+// mid-body is fetched again instead of parsed
+func TestTruncatedResponse(t *testing.T) {
+	base := newTestSite(t, "1")
+	baseCfg := base.config(t)
+	if err := run(baseCfg); err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile(filepath.Join(baseCfg.OutputDir, "1", fullFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	site := newTestSite(t, "1")
+	site.truncate = 1
+	cfg := site.config(t)
+	var err2 error
+	out := captureLog(t, func() { err2 = run(cfg) })
+	if err2 != nil {
+		t.Fatal(err2)
+	}
+	if got, want := site.requests["/day"], base.requests["/day"]+1; got != want {
+		t.Errorf("%d day requests, want %d with the retry", got, want)
+	}
+	if !strings.Contains(out, "truncated response") {
+		t.Errorf("truncation not logged:\n%s", out)
+	}
+	got, err := os.ReadFile(filepath.Join(cfg.OutputDir, "1", fullFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("feed after the retry:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// TestParsePrices checks the conversion of German prices, thousands
+// separators included, and that no malformed price makes it into the feed
+func TestParsePrices(t *testing.T) {
+	for _, test := range []struct {
+		text string
+		want string // prices joined by " "
+	}{
+		{"€ 1,95 / 3,10 / 4,65", "1.95 3.10 4.65"},
+		{"2,50 €", "2.50"},
+		{"1.234,56 €", "1234.56"},
+		{"€ 12.345.678,90", "12345678.90"},
+		{"1.23,45", "23.45"},
+		{"3,4", ""},
+		{"1.50", ""},
+		{"keine Angabe", ""},
+	} {
+		got := parsePrices(test.text)
+		if strings.Join(got, " ") != test.want {
+			t.Errorf("parsePrices(%q) = %q, want %q", test.text, got, test.want)
+		}
+		for _, price := range got {
+			if strings.Count(price, ".") != 1 {
+				t.Errorf("parsePrices(%q) has malformed %q", test.text, price)
+			}
+		}
+	}
+
+	for _, test := range []struct {
+		s    string
+		want string
+		ok   bool
+	}{
+		{"3,45", "3.45", true},
+		{"1.234,56", "1234.56", true},
+		{"1.234.56", "123456", false},
+		{"1,2,3", "1.2,3", false},
+	} {
+		got, ok := parsePrice(test.s)
+		if got != test.want || ok != test.ok {
+			t.Errorf("parsePrice(%q) = %q, %v, want %q, %v", test.s, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+// TestSplitPortions checks the portions of price cells with leading and
+// trailing labels and the cells that are not split
+func TestSplitPortions(t *testing.T) {
+	for _, test := range []struct {
+		text string
+		want string // portions as "label: prices" joined by " | "
+	}{
+		{"klein € 2,50 / groß € 3,80", "klein:  € 2,50 /  | groß:  € 3,80"},
+		{"2,50 € (klein) / 3,80 € (groß)", "klein: 2,50 € ( | groß: ) / 3,80 € ("},
+		{"Klein 1.234,56 € Gross 2,00 €", "klein:  1.234,56 €  | groß:  2,00 €"},
+		{"€ 2,50 / 3,80", ""},
+		{"klein € 2,50", ""},
+		{"klein / groß € 3,80", ""},
+	} {
+		var got []string
+		for _, p := range splitPortions(test.text) {
+			got = append(got, p.label+": "+p.prices)
+		}
+		if strings.Join(got, " | ") != test.want {
+			t.Errorf("splitPortions(%q) = %q, want %q", test.text, strings.Join(got, " | "), test.want)
+		}
+	}
+}
+
+// TestMealName checks that the footnote markers are split off the name of
+// a meal, once each
+func TestMealName(t *testing.T) {
+	for _, test := range []struct {
+		html      string
+		name      string
+		footnotes string
+	}{
+		{`<span>Schnitzel<sup>1,a</sup></span>`, "Schnitzel", "1 a"},
+		{`<span>Reis <span class="fussnote">(2, 2)</span> mit Gemüse<sup>b</sup></span>`, "Reis mit Gemüse", "2 b"},
+		{`<span>Linsen &amp; Spätzle</span>`, "Linsen & Spätzle", ""},
+	} {
+		s := parseDoc(t, test.html).Find("span").First()
+		name, footnotes := mealName(s)
+		var got []string
+		for _, n := range footnotes {
+			got = append(got, string(n))
+		}
+		if name != test.name || strings.Join(got, " ") != test.footnotes {
+			t.Errorf("mealName(%s) = %q, %q, want %q, %q", test.html, name, got, test.name, test.footnotes)
+		}
+		if s.Find("sup").Length() == 0 && strings.Contains(test.html, "<sup>") {
+			t.Errorf("mealName(%s) changed the selection", test.html)
+		}
+	}
+}
+
+// TestStrict checks that -strict fails a run with a parse warning only and
+// that the default stays lenient
+func TestStrict(t *testing.T) {
+	unknownIcon := strings.Replace(testDay, `<div class="text-right">`,
+		`<img class="splIcon" src="/vendor/infomax/mensen/icons/neu.png"><div class="text-right">`, 1)
+	for _, test := range []struct {
+		day    string
+		strict bool
+		fails  bool
+	}{
+		{testDay, true, false},
+		{unknownIcon, true, true},
+		{unknownIcon, false, false},
+	} {
+		site := newTestSite(t, "1")
+		site.day = test.day
+		cfg := site.config(t)
+		cfg.Strict = test.strict
+		err := run(cfg)
+		if fails := err != nil; fails != test.fails {
+			t.Errorf("strict %v: run failed %v, want %v: %v", test.strict, fails, test.fails, err)
+		}
+		if err != nil && !strings.HasPrefix(err.Error(), "strict:") {
+			t.Errorf("strict %v: %v", test.strict, err)
+		}
+	}
+}
+
+// TestStrictClosedDetection checks that with -strict-closed-detection only
+// a page saying so is a closed day, other pages without meals are skipped
+func TestStrictClosedDetection(t *testing.T) {
+	closedNotice := strings.Replace(testNoMeals, "</body>", "<br>Kein Speisenangebot</body>", 1)
+	for _, test := range []struct {
+		day    string
+		strict bool
+		err    error
+	}{
+		{testNoMeals, true, errUnrecognizedEmpty},
+		{closedNotice, true, nil},
+		{testNoMeals, false, nil},
+	} {
+		cfg := defaultConfig()
+		cfg.StrictClosedDetection = test.strict
+		p := NewParser(cfg)
+		d, err := p.dayOf("1", "2026-10-14", parseDoc(t, test.day))
+		if err != test.err {
+			t.Errorf("strict %v: dayOf error %v, want %v", test.strict, err, test.err)
+		}
+		if !d.closed() {
+			t.Errorf("strict %v: day has meals", test.strict)
+		}
+	}
+
+	site := newTestSite(t, "1")
+	site.day = testNoMeals
+	cfg := site.config(t)
+	cfg.StrictClosedDetection = true
+	cfg.Strict = true
+	var err error
+	out := captureLog(t, func() { err = run(cfg) })
+	if err == nil || !strings.Contains(out, errUnrecognizedEmpty.Error()) {
+		t.Errorf("unrecognized empty page passed strict run: %v\n%s", err, out)
+	}
+}
+
+// TestRoleTable checks the roles by column label, inline label and number
+// of prices, with -price-role-map and -price-count on top of the defaults
+func TestRoleTable(t *testing.T) {
+	table := newRoleTable(
+		map[string]string{"Azubis": "pupil", "2": "pupil", "single": "student"},
+		map[int][]string{2: {"student", "other"}},
+	)
+	for _, test := range []struct {
+		text string
+		n    int
+		want string // roles joined by " "
+	}{
+		{"Gäste / Studierende / Bedienstete", 3, "other student employee"},
+		{"Schüler | Beschäftigte", 2, "pupil employee"},
+		{"AZUBIS", 1, "pupil"},
+		{"Studierende / Gäste", 3, ""},
+		{"Studierende", 0, ""},
+		{"€ 2,50", 1, ""},
+	} {
+		if got := strings.Join(table.labeled(test.text, test.n), " "); got != test.want {
+			t.Errorf("labeled(%q, %d) = %q, want %q", test.text, test.n, got, test.want)
+		}
+	}
+
+	for n, want := range map[int]string{1: "student", 2: "student other", 3: "student pupil other", 4: ""} {
+		if got := strings.Join(table.counts[n], " "); got != want {
+			t.Errorf("roles of %d prices %q, want %q", n, got, want)
+		}
+	}
+	if got := table.expected(); got != "0, 1, 2 or 3" {
+		t.Errorf("expected() = %q", got)
+	}
+	if got := strings.Join(newRoleTable(nil, nil).counts[3], " "); got != "student employee other" {
+		t.Errorf("default roles of 3 prices %q, changed by another table", got)
+	}
+
+	var got []string
+	for _, price := range table.inline("Bedienstete: 3,80 € | Azubis 1.002,50 | Gäste € 4,90 | Sonstige 5,00") {
+		got = append(got, price.Role+"="+price.Price)
+	}
+	if want := "employee=3.80 pupil=1002.50 other=4.90"; strings.Join(got, " ") != want {
+		t.Errorf("inline prices %q, want %q", got, want)
+	}
+}
+
+// TestLabeledPriceColumns checks that the column headers of a category
+// assign the roles of reordered prices
+func TestLabeledPriceColumns(t *testing.T) {
+	day := `<html><body>
+<div class="splGroupWrapper"><div class="splGroup">Essen</div>
+<div class="text-right">Gäste / Bedienstete / Studierende</div>
+<div class="splMeal"><span class="bold">Schnitzel</span><div class="text-right">€ 4,65/3,10/1,95</div></div>
+</div>
+</body></html>`
+	p := NewParser(defaultConfig())
+	d := p.parseDay("1", "2026-10-14", parseDoc(t, day))
+	if len(d.Categories) != 1 || len(d.Categories[0].Meals) != 1 {
+		t.Fatalf("parsed %+v", d)
+	}
+	var got []string
+	for _, price := range d.Categories[0].Meals[0].Prices {
+		got = append(got, price.Role+"="+price.Price)
+	}
+	if want := "other=4.65 employee=3.10 student=1.95"; strings.Join(got, " ") != want {
+		t.Errorf("prices %q, want %q", got, want)
+	}
+}
+
+// TestDateWindowDST checks that the window around the DST changes of Berlin
+// has each date once, in order and without gaps
+func TestDateWindowDST(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	for _, anchor := range []time.Time{
+		time.Date(2026, 3, 29, 0, 0, 0, 0, berlin),
+		time.Date(2026, 3, 29, 2, 30, 0, 0, berlin), // does not exist
+		time.Date(2026, 3, 28, 23, 30, 0, 0, berlin),
+		time.Date(2026, 10, 25, 2, 30, 0, 0, berlin), // occurs twice
+		time.Date(2026, 10, 24, 23, 59, 59, 0, berlin),
+	} {
+		dates := dateWindow(anchor, -3, 3)
+		if len(dates) != 7 {
+			t.Errorf("%s: window %q", anchor, dates)
+			continue
+		}
+		if dates[3] != anchor.Format("2006-01-02") {
+			t.Errorf("%s: window %q not around the anchor", anchor, dates)
+		}
+		for i := 1; i < len(dates); i++ {
+			prev, _ := time.Parse("2006-01-02", dates[i-1])
+			if next := prev.AddDate(0, 0, 1).Format("2006-01-02"); dates[i] != next {
+				t.Errorf("%s: window %q: %s follows %s", anchor, dates, dates[i], dates[i-1])
+			}
+		}
+	}
+
+	// the feed has a single day per date of the window
+	site := newTestSite(t, "1")
+	p := NewParser(site.config(t))
+	anchor := time.Date(2026, 10, 25, 2, 30, 0, 0, berlin)
+	c, err := p.Meals(context.Background(), "1", nil, anchor, -3, 3, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, d := range c.Days {
+		if seen[d.Date] {
+			t.Errorf("day %s twice in the feed", d.Date)
+		}
+		seen[d.Date] = true
+	}
+	if len(seen) != 7 {
+		t.Errorf("%d days in the feed, want 7", len(seen))
+	}
+}
+
+// memSink is an OutputSink keeping the files in memory
+type memSink struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func (s *memSink) Write(path string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[path] = data
+	return nil
+}
+
+// TestMemorySink checks that a run writes all its output through the sink,
+// the same files as to OutputDir and nothing to OutputDir itself
+func TestMemorySink(t *testing.T) {
+	site := newTestSite(t, "1", "2")
+	cfg := site.config(t)
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	err := filepath.Walk(cfg.OutputDir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			rel, _ := filepath.Rel(cfg.OutputDir, path)
+			want = append(want, filepath.ToSlash(rel))
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.OutputDir = t.TempDir()
+	p := NewParser(cfg)
+	sink := &memSink{files: make(map[string][]byte)}
+	p.output = sink
+	if err := runParser(p); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for path := range sink.files {
+		got = append(got, path)
+	}
+	sort.Strings(got)
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("sink got %q, want %q", got, want)
+	}
+	for _, path := range []string{"1/" + metadataFile, "1/" + fullFile, "2/" + fullFile, idsAllFile} {
+		if len(sink.files[path]) == 0 {
+			t.Errorf("%s empty or missing", path)
+		}
+	}
+	if entries, err := os.ReadDir(cfg.OutputDir); err != nil || len(entries) != 0 {
+		t.Errorf("run with a sink wrote %d files to the output directory: %v", len(entries), err)
+	}
+}
+
+// TestStamp checks that -stamp marks the feeds with the time of the run and
+// the tool version, and that the feeds have no comment by default
+func TestStamp(t *testing.T) {
+	defer func(v string) { version = v }(version)
+	version = "v1.2.3"
+	for _, stamp := range []bool{true, false} {
+		site := newTestSite(t, "1")
+		cfg := site.config(t)
+		cfg.Stamp = stamp
+		cfg.TodayFeed = true
+		p := NewParser(cfg)
+		p.anchor = time.Date(2026, 10, 14, 12, 30, 0, 0, time.FixedZone("CEST", 2*60*60))
+		if err := runParser(p); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{fullFile, todayFile} {
+			data, err := os.ReadFile(filepath.Join(cfg.OutputDir, "1", name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := "\n  <!-- generated 2026-10-14T10:30:00Z by openmensa-parser-berlin v1.2.3 -->\n  <canteen>"
+			if stamp && !strings.Contains(string(data), want) {
+				t.Errorf("%s lacks the stamp:\n%s", name, data)
+			}
+			if !stamp && strings.Contains(string(data), "<!--") {
+				t.Errorf("%s has a comment without -stamp:\n%s", name, data)
+			}
+		}
+	}
+}
+
+// TestReconcileNotes checks the merging of icon and text notes, preferring
+// the most specific diet
+func TestReconcileNotes(t *testing.T) {
+	for _, test := range []struct {
+		icons, text []Note
+		want        string
+		conflict    bool
+	}{
+		{[]Note{"vegetarisch", "bio"}, []Note{"vegan"}, "vegan bio", true},
+		{[]Note{"vegan"}, []Note{"vegetarisch", "Knoblauch"}, "vegan Knoblauch", true},
+		{[]Note{"bio"}, []Note{"Vegetarisch"}, "bio vegetarisch", false},
+		{[]Note{"vegan", "MSC"}, []Note{"vegan", "MSC"}, "vegan MSC", false},
+		{nil, nil, "", false},
+	} {
+		notes, conflict := reconcileNotes(test.icons, test.text)
+		if got := joinNotes(notes); got != test.want || conflict != test.conflict {
+			t.Errorf("reconcileNotes(%q, %q) = %q, %v, want %q, %v", test.icons, test.text, got, conflict, test.want, test.conflict)
+		}
+	}
+}
+
+func TestMarkerNotes(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		notes []Note
+		want  string
+	}{
+		{"Ente", []Note{"bitte vorbestellen", "bio"}, "Vorbestellung erforderlich bio"},
+		{"Ente (nur auf Vorbestellung)", []Note{"bio"}, "bio Vorbestellung erforderlich"},
+		{"Ente", []Note{"Vorbestellung", "VORBESTELLT"}, "Vorbestellung erforderlich"},
+		{"Ente", []Note{"bio"}, "bio"},
+	} {
+		if got := joinNotes(markerNotes(test.name, test.notes)); got != test.want {
+			t.Errorf("markerNotes(%q, %q) = %q, want %q", test.name, test.notes, got, test.want)
+		}
+	}
+}
+
+func TestStripNotes(t *testing.T) {
+	notes := []Note{"vegan", " Knoblauch ", "bio", "Knoblauchöl"}
+	if got := joinNotes(stripNotes(append([]Note(nil), notes...), nil)); got != joinNotes(notes) {
+		t.Errorf("nothing to ignore, got %q", got)
+	}
+	if got := joinNotes(stripNotes(notes, []string{"Knoblauch", "bio"})); got != "vegan Knoblauchöl" {
+		t.Errorf("stripped to %q", got)
+	}
+}
+
+// joinNotes returns notes joined by spaces, for comparisons
+func joinNotes(notes []Note) string {
+	s := make([]string, len(notes))
+	for i, n := range notes {
+		s[i] = string(n)
+	}
+	return strings.Join(s, " ")
+}
+
+// TestMealNotes checks the notes of a meal whose diet icon and label
+// disagree, with a marker and an ignored note, and their rendering with
+// -emit-notes-as-attributes
+func TestMealNotes(t *testing.T) {
+	day := strings.Replace(testDay, `<div class="text-right">`, `<img class="splIcon" src="/icons/1.png">`+
+		`<div class="kennz"><table><tr><td>vegan</td><td>Vorbestellung erbeten</td><td>Knoblauch</td></tr></table></div>`+
+		`<div class="text-right">`, 1)
+	cfg := defaultConfig()
+	cfg.IgnoreNotes = idList{"Knoblauch"}
+	p := NewParser(cfg)
+	var d Day
+	out := captureLog(t, func() { d = p.parseDay("1", "2026-10-14", parseDoc(t, day)) })
+	if len(d.Categories) != 1 || len(d.Categories[0].Meals) != 1 {
+		t.Fatalf("parsed %+v", d)
+	}
+	if got := joinNotes(d.Categories[0].Meals[0].Notes); got != "vegan Vorbestellung erforderlich" {
+		t.Errorf("notes %q", got)
+	}
+	if !strings.Contains(out, "disagree") {
+		t.Errorf("conflict not logged:\n%s", out)
+	}
+
+	for _, compact := range []bool{true, false} {
+		var b strings.Builder
+		c := &Canteen{CompactNotes: compact, Days: []Day{d}}
+		if err := c.Write(&b); err != nil {
+			t.Fatal(err)
+		}
+		want := "<meal>\n          <name>Schnitzel</name>\n          <note>vegan</note>\n          <note>Vorbestellung erforderlich</note>"
+		if compact {
+			want = `<meal notes="vegan; Vorbestellung erforderlich">` + "\n          <name>Schnitzel</name>\n          <price"
+		}
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("compact %v:\n%s", compact, b.String())
+		}
+	}
+}
+
+// TestStruckPrices checks that crossed-out old prices are left out, so that
+// the current ones keep their roles
+func TestStruckPrices(t *testing.T) {
+	p := NewParser(defaultConfig())
+	for _, cell := range []string{
+		`<del>€ 1,80/2,90/4,20</del> € 1,95/3,10/4,65`,
+		`<s>€ 1,80</s>€ 1,95/<s>2,90</s>3,10/<s>4,20</s>4,65`,
+		`<span class="old-price">€ 1,80/2,90/4,20</span><span>€ 1,95/3,10/4,65</span>`,
+		`<span style="text-decoration: line-through">€ 1,80/2,90/4,20</span> € 1,95/3,10/4,65`,
+		`€ 1,95/3,10/4,65 <strike>statt 4,90</strike>`,
+	} {
+		day := strings.Replace(testDay, "€ 1,95/3,10/4,65", cell, 1)
+		d := p.parseDay("1", "2026-10-14", parseDoc(t, day))
+		if len(d.Categories) != 1 || len(d.Categories[0].Meals) != 1 {
+			t.Fatalf("%s: parsed %+v", cell, d)
+		}
+		var got []string
+		for _, price := range d.Categories[0].Meals[0].Prices {
+			got = append(got, price.Role+"="+price.Price)
+		}
+		if want := "student=1.95 employee=3.10 other=4.65"; strings.Join(got, " ") != want {
+			t.Errorf("%s: prices %q, want %q", cell, got, want)
+		}
+	}
+}
+
+func TestParseEmail(t *testing.T) {
+	for _, test := range []struct {
+		html string
+		want string
+		err  bool
+	}{
+		{`<div><a href="mailto:mensa%2Dnord@stw.berlin?subject=Frage">Mail</a></div>`, "mensa-nord@stw.berlin", false},
+		{`<div><a href="mailto:mensa@stw.berlin">mensa (at) andere.de</a></div>`, "mensa@stw.berlin", false},
+		{`<div>mensa [ät] stw.berlin</div>`, "mensa@stw.berlin", false},
+		{`<div>mensa{at}stw.berlin</div>`, "mensa@stw.berlin", false},
+		{`<div> </div>`, "", false},
+		{`<div>Mensa Nord &lt;mensa@stw.berlin&gt;</div>`, "", true},
+		{`<div>kein Kontakt</div>`, "", true},
+	} {
+		got, err := parseEmail(parseDoc(t, test.html).Find("div"))
+		if got != test.want || (err != nil) != test.err {
+			t.Errorf("parseEmail(%s) = %q, %v, want %q", test.html, got, err, test.want)
+		}
+	}
+}
+
+func TestParseStand(t *testing.T) {
+	for text, want := range map[string]string{
+		"Stand: 14.10.2026":             "2026-10-14",
+		"Speiseplan (Stand 3.9.2026) …": "2026-09-03",
+		"Stand:01.01.2027 und 2.1.2027": "2027-01-01",
+		"Stand: 31.02.2026":             "",
+		"stand: 14.10.2026":             "",
+		"Stand: 14.10.26":               "",
+		"Aktualisiert am 14.10.2026":    "",
+	} {
+		if got := parseStand(text); got != want {
+			t.Errorf("parseStand(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestParseTransit(t *testing.T) {
+	for _, test := range []struct {
+		html string
+		want string // stops joined by "|"
+	}{
+		{`<p>Verkehrsanbindung: U2 Ernst-Reuter-Platz, Bus 245 und M45.</p>`, "U2 Ernst-Reuter-Platz|Bus 245|M45"},
+		{`<ul><li>ÖPNV: S Savignyplatz; Bus X34</li><li>Haltestelle: Bus X34 / Bus 245</li></ul>`, "S Savignyplatz|Bus X34|Bus 245"},
+		{`<p>Anfahrt :<span>U7   Rathaus Spandau</span></p>`, "U7 Rathaus Spandau"},
+		{`<p>Haltestellen in der Nähe</p>`, ""},
+	} {
+		if got := strings.Join(parseTransit(parseDoc(t, test.html)), "|"); got != test.want {
+			t.Errorf("parseTransit(%s) = %q, want %q", test.html, got, test.want)
+		}
+	}
+}
+
+func TestParseOperator(t *testing.T) {
+	for _, test := range []struct {
+		html string
+		want string
+	}{
+		{`<div><p>Betreiber: Kochwerk GmbH.</p><p>Sitzplätze: 200</p></div>`, "Kochwerk GmbH"},
+		{`<div>Die Cafeteria wird betrieben von <span>Betreiberin: Café Kiez e.V.</span></div>`, "Café Kiez e.V"},
+		{`<div><p>Betrieben durch Mensa Catering AG;</p></div>`, "Mensa Catering AG"},
+		{`<div><p>Betreiber: studierendenWERK BERLIN</p></div>`, ""},
+		{`<div><p>Öffnungszeiten: Mo.–Fr. 11:00–14:30</p></div>`, ""},
+	} {
+		if got := parseOperator(parseDoc(t, test.html)); got != test.want {
+			t.Errorf("parseOperator(%s) = %q, want %q", test.html, got, test.want)
+		}
+	}
+}
+
+func TestParseAccessibility(t *testing.T) {
+	for _, test := range []struct {
+		html string
+		want string
+	}{
+		{`<p>Die Mensa ist barrierefrei zugänglich.</p>`, "barrierefrei"},
+		{`<p>Eingeschränkt   Barrierefrei (Aufzug)</p>`, "eingeschränkt barrierefrei"},
+		{`<img src="rolli.png" alt="Rollstuhlgerecht"><p>barrierefrei</p>`, "rollstuhlgerecht"},
+		{`<i class="icon" title="nicht barrierefrei"></i>`, "nicht barrierefrei"},
+		{`<img src="logo.png" alt="Logo"><p>Teilweise rollstuhlzugänglich</p>`, "teilweise rollstuhlzugänglich"},
+		{`<p>Keine Angabe</p>`, ""},
+	} {
+		if got := parseAccessibility(parseDoc(t, test.html)); got != test.want {
+			t.Errorf("parseAccessibility(%s) = %q, want %q", test.html, got, test.want)
+		}
+	}
+}
+
+func TestParseSemesterBreak(t *testing.T) {
+	for _, test := range []struct {
+		html     string
+		note     string // empty if there is no break
+		from, to string
+	}{
+		{
+			`<div><p>Mo.–Fr. 11:00–14:30</p><p>Öffnungszeiten abweichend in den Semesterferien (15.07.2026 – 14.10.2026): Mo. – Fr. 11:00 – 14:00 Uhr</p></div>`,
+			"Öffnungszeiten abweichend in den Semesterferien (15.07.2026 – 14.10.2026): Mo. – Fr. 11:00 – 14:00 Uhr", "2026-07-15", "2026-10-14",
+		},
+		{
+			`<div><ul><li>In der vorlesungsfreien Zeit   nur bis 14 Uhr</li></ul></div>`,
+			"In der vorlesungsfreien Zeit nur bis 14 Uhr", "", "",
+		},
+		{
+			`<p>Semesterferien ab 1.8.2026 geschlossen</p>`,
+			"Semesterferien ab 1.8.2026 geschlossen", "", "",
+		},
+		{`<p>Mo.–Fr. 11:00–14:30</p>`, "", "", ""},
+	} {
+		b := parseSemesterBreak(parseDoc(t, test.html))
+		switch {
+		case b == nil && test.note != "":
+			t.Errorf("parseSemesterBreak(%s) = nil", test.html)
+		case b != nil && test.note == "":
+			t.Errorf("parseSemesterBreak(%s) = %+v, want nil", test.html, b)
+		case b != nil && (b.Note != test.note || b.From != test.from || b.To != test.to):
+			t.Errorf("parseSemesterBreak(%s) = %+v, want %q from %q to %q", test.html, b, test.note, test.from, test.to)
+		}
+	}
+}
+
+// TestVerifyUrls checks that -verify-urls sends a HEAD request to the
+// published feeds, or to -verify-sample of them, and fails the run for the
+// feeds that do not resolve
+func TestVerifyUrls(t *testing.T) {
+	for _, test := range []struct {
+		sample int
+		heads  string
+		failed string
+	}{
+		{0, "/1/full.xml /2/full.xml /3/full.xml /4/full.xml /5/full.xml", "2"},
+		{2, "/1/full.xml /4/full.xml", ""},
+	} {
+		var mu sync.Mutex
+		var heads []string
+		published := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			if r.Method != http.MethodHead {
+				t.Errorf("%s request", r.Method)
+			}
+			heads = append(heads, r.URL.Path)
+			if r.URL.Path == "/2/"+fullFile {
+				http.NotFound(w, r)
+			}
+		}))
+		defer published.Close()
+
+		site := newTestSite(t, "1", "2", "3", "4", "5")
+		cfg := site.config(t)
+		cfg.FeedBase = published.URL + "/"
+		cfg.VerifyURLs = true
+		cfg.VerifySample = test.sample
+		cfg.ErrorsReport = filepath.Join(t.TempDir(), "errors.json")
+		err := run(cfg)
+		if (err != nil) != (test.failed != "") {
+			t.Errorf("sample %d: run error %v", test.sample, err)
+		}
+		if got := strings.Join(heads, " "); got != test.heads {
+			t.Errorf("sample %d: checked %s, want %s", test.sample, got, test.heads)
+		}
+		var errs []RunError
+		readJSON(t, cfg.ErrorsReport, &errs)
+		var failed []string
+		for _, e := range errs {
+			if e.Phase == "verify" && !e.Fatal {
+				failed = append(failed, e.Id)
+			}
+		}
+		if got := strings.Join(failed, " "); got != test.failed || len(errs) != len(failed) {
+			t.Errorf("sample %d: errors %+v, want verify errors of %q", test.sample, errs, test.failed)
+		}
+	}
+}
+
+// TestIconReport checks that -icon-report lists every distinct meal icon
+// with its count and first sighting, unknown icons first
+func TestIconReport(t *testing.T) {
+	site := newTestSite(t, "1", "2")
+	meal := `<div class="splMeal"><span class="bold">%s</span>%s<div class="text-right">€ 1,95/3,10/4,65</div></div>`
+	site.day = `<html><body><div class="splGroupWrapper"><div class="splGroup">Essen</div>` +
+		fmt.Sprintf(meal, "Tofu", `<img class="splIcon" src="/icons/15.png">`) +
+		fmt.Sprintf(meal, "Seitan", `<img class="splIcon" src="/icons/15.png"><img class="splIcon" src="/icons/neu.png?v=2">`) +
+		`</div></body></html>`
+	cfg := site.config(t)
+	cfg.Concurrency = 1
+	cfg.IconReport = filepath.Join(t.TempDir(), "icons.json")
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	var icons []iconSighting
+	readJSON(t, cfg.IconReport, &icons)
+	date := time.Now().Format("2006-01-02")
+	want := []iconSighting{
+		{Src: "/icons/neu.png?v=2", Count: 2, Id: "1", Date: date},
+		{Src: "/icons/15.png", Note: "vegan", Count: 4, Id: "1", Date: date},
+	}
+	if len(icons) != len(want) {
+		t.Fatalf("icons %+v, want %+v", icons, want)
+	}
+	for i := range want {
+		if icons[i] != want[i] {
+			t.Errorf("icon %d: %+v, want %+v", i, icons[i], want[i])
+		}
+	}
+
+	// without -icon-report the probe is nil and discards the sightings
+	var probe *iconProbe
+	probe.see("/icons/15.png", "1", date, "vegan")
+}
+
+// TestRequestLog checks that -request-log records every request, retries
+// included, as a JSON line
+func TestRequestLog(t *testing.T) {
+	site := newTestSite(t, "1")
+	site.truncate = 1
+	site.status["1"] = http.StatusNotFound
+	cfg := site.config(t)
+	cfg.RequestLog = filepath.Join(t.TempDir(), "requests.jsonl")
+	run(cfg)
+
+	data, err := os.ReadFile(cfg.RequestLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, line := range strings.SplitAfter(strings.TrimSpace(string(data)), "\n") {
+		var r requestRecord
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("%q: %s", line, err)
+		}
+		if r.Method != http.MethodPost || r.Time.IsZero() || r.Duration == "" {
+			t.Errorf("record %+v", r)
+		}
+		got = append(got, fmt.Sprintf("%s %s %d %d", strings.TrimPrefix(r.Url, site.URL), r.Form.Get("resources_id"), r.Attempt, r.Status))
+	}
+	want := "/meta 0 1 200, /meta 1 1 404, /day 1 1 200, /day 1 2 200"
+	if strings.Join(got, ", ") != want {
+		t.Errorf("requests %s, want %s", strings.Join(got, ", "), want)
+	}
+}
+
+func TestCapIds(t *testing.T) {
+	all := []string{"1", "2", "3", "10", "11", "12"}
+	cur := []string{"2", "11"}
+	for _, test := range []struct {
+		max  int
+		want string
+	}{
+		{6, "1 2 3 10 11 12"},
+		{10, "1 2 3 10 11 12"},
+		{4, "2 10 11 12"},
+		{3, "2 11 12"},
+		{1, "2 11"},
+	} {
+		got := capIds(append([]string(nil), all...), cur, test.max)
+		if strings.Join(got, " ") != test.want {
+			t.Errorf("capIds(%d) = %q, want %q", test.max, got, test.want)
+		}
+	}
+}
+
+// TestCompactIdsArchive checks that -compact-ids-archive forgets the lowest
+// archived canteens, and that ids_archive follows
+func TestCompactIdsArchive(t *testing.T) {
+	for _, test := range []struct {
+		max          int
+		all, archive string
+	}{
+		{0, "1 2 3 4 5 6 7 8", "1 2 3 4 7 8"},
+		{4, "5 6 7 8", "7 8"},
+		{1, "5 6", ""},
+	} {
+		site := newTestSite(t, "5", "6")
+		cfg := site.config(t)
+		cfg.MaxIdsAll = test.max
+		if err := os.WriteFile(filepath.Join(cfg.OutputDir, idsAllFile), []byte("1\n2\n3\n4\n7\n8\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := run(cfg); err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(readIds(t, cfg.OutputDir, idsAllFile), " "); got != test.all {
+			t.Errorf("max %d: %s %q, want %q", test.max, idsAllFile, got, test.all)
+		}
+		if got := strings.Join(readIds(t, cfg.OutputDir, idsArchiveFile), " "); got != test.archive {
+			t.Errorf("max %d: %s %q, want %q", test.max, idsArchiveFile, got, test.archive)
+		}
+	}
+}
+
+// TestMaxRequests checks that a run stops at -max-requests without another
+// request and before the index and state files are written
+func TestMaxRequests(t *testing.T) {
+	site := newTestSite(t, "1", "2", "3")
+	cfg := site.config(t)
+	cfg.Concurrency = 1
+	// the listing, the metadata of all canteens and the meals of 1
+	cfg.MaxRequests = 5
+	err := run(cfg)
+	if !errors.Is(err, errRequestCap) {
+		t.Fatalf("capped run: %v", err)
+	}
+	if n := site.requests["/meta"] + site.requests["/day"]; n != cfg.MaxRequests {
+		t.Errorf("%d requests, want %d", n, cfg.MaxRequests)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.OutputDir, "1", fullFile)); err != nil {
+		t.Errorf("canteen fetched before the cap: %s", err)
+	}
+	for _, name := range []string{indexFile, idsAllFile, idsCurFile, idsArchiveFile, idsMarkFile, "2/" + fullFile} {
+		if _, err := os.Stat(filepath.Join(cfg.OutputDir, name)); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s written by the capped run: %v", name, err)
+		}
+	}
+
+	cfg.MaxRequests = 0
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if ids := readIds(t, cfg.OutputDir, idsAllFile); strings.Join(ids, " ") != "1 2 3" {
+		t.Errorf("ids_all after the uncapped run: %v", ids)
+	}
+}
+
+// TestNoMetadata checks that -no-metadata refreshes the feeds but keeps the
+// metadata files, fetching only that of a canteen that has none yet
+func TestNoMetadata(t *testing.T) {
+	site := newTestSite(t, "1", "2")
+	cfg := site.config(t)
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	metadata := filepath.Join(cfg.OutputDir, "1", metadataFile)
+	before, err := os.ReadFile(metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	site.set(func() {
+		site.ids = append(site.ids, "3")
+		site.info["1"] = `<div><i class="glyphicon glyphicon-earphone"></i></div><div>Tel. 030 939 39 7000</div>`
+		site.day = strings.Replace(testDay, "Schnitzel", "Bratwurst", 1)
+		site.requests = make(map[string]int)
+	})
+	cfg.NoMetadata = true
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if after, err := os.ReadFile(metadata); err != nil || !bytes.Equal(after, before) {
+		t.Errorf("metadata rewritten with -no-metadata: %v\n%s", err, after)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.OutputDir, "3", metadataFile)); err != nil {
+		t.Errorf("metadata of the new canteen: %s", err)
+	}
+	// the listing and the metadata of 3
+	if n := site.requests["/meta"]; n != 2 {
+		t.Errorf("%d metadata requests, want 2", n)
+	}
+	for _, id := range []string{"1", "2", "3"} {
+		feed, err := os.ReadFile(filepath.Join(cfg.OutputDir, id, fullFile))
+		if err != nil || !bytes.Contains(feed, []byte("<name>Bratwurst</name>")) {
+			t.Errorf("feed of %s not refreshed: %v", id, err)
+		}
+	}
+}
+
+// TestFetchIdsValueless checks that listing options without value yield no
+// id and duplicates only one
+func TestFetchIdsValueless(t *testing.T) {
+	listing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><body><select id="listboxEinrichtungen">
+<option>Bitte wählen</option><option value="">–</option><option value="  ">leer</option>
+<option value="2">Mensa 2</option><option value=" 1 ">Mensa 1</option><option value="2">Mensa 2</option>
+</select></body></html>`)
+	}))
+	defer listing.Close()
+	cfg := defaultConfig()
+	cfg.MetaURL = listing.URL
+	ids, err := NewParser(cfg).FetchIds(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sortIds(&ids)
+	if strings.Join(ids, " ") != "1 2" {
+		t.Errorf("ids %q", ids)
+	}
+}
+
+// TestEmitEmptyDays checks that closed days are emitted as closed by default
+// and omitted with -emit-empty-days=false, while days whose page is not
+// recognized as closed are skipped in both modes
+func TestEmitEmptyDays(t *testing.T) {
+	closed := strings.Replace(testNoMeals, "</body>", "<br>Kein Speisenangebot</body>", 1)
+	for _, test := range []struct {
+		emit         bool
+		days, closed int
+	}{
+		{true, 3, 1},
+		{false, 2, 0},
+	} {
+		site := newTestSite(t, "1")
+		site.days = []string{testDay, closed, testNoMeals, testDay}
+		cfg := site.config(t)
+		cfg.DaysAfter = 3
+		cfg.EmitEmptyDays = test.emit
+		cfg.StrictClosedDetection = true
+		if err := run(cfg); err != nil {
+			t.Fatal(err)
+		}
+		feed, err := os.ReadFile(filepath.Join(cfg.OutputDir, "1", fullFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		days, closedDays := bytes.Count(feed, []byte("<day ")), bytes.Count(feed, []byte("<closed"))
+		if days != test.days || closedDays != test.closed {
+			t.Errorf("emit %v: %d days, %d closed, want %d and %d:\n%s", test.emit, days, closedDays, test.days, test.closed, feed)
+		}
+	}
+}
+
+// TestUpdateGolden checks the regeneration path of -compare-golden: a
+// fixture without golden or with a stale one fails until -update rewrites
+// the goldens, which leaves the output directory alone
+func TestUpdateGolden(t *testing.T) {
+	dir := t.TempDir()
+	cfg := defaultConfig()
+	cfg.OutputDir = t.TempDir()
+	cfg.GoldenDir = dir
+	if err := run(cfg); err == nil || !strings.Contains(err.Error(), "no *.html fixtures") {
+		t.Errorf("run without fixtures: %v", err)
+	}
+
+	for name, golden := range map[string]string{
+		"1_2026-10-14": strings.Replace(testGolden, "0.65", "0.75", 1), // stale
+		"2_2026-10-14": "",                                             // missing
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name+".html"), []byte(testTwoCategories), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if golden != "" {
+			if err := os.WriteFile(filepath.Join(dir, name+".xml"), []byte(golden), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := run(cfg); err == nil {
+		t.Error("run against stale and missing goldens succeeded")
+	}
+
+	work := cfg
+	work.OutputDir = t.TempDir()
+	work.UpdateGolden = true
+	if err := run(work); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"1_2026-10-14", "2_2026-10-14"} {
+		if data, err := os.ReadFile(filepath.Join(dir, name+".xml")); err != nil || string(data) != testGolden {
+			t.Errorf("%s regenerated %v:\n%s", name, err, data)
+		}
+	}
+	if entries, err := os.ReadDir(work.OutputDir); err != nil || len(entries) != 0 {
+		t.Errorf("-update wrote %d files to the output directory: %v", len(entries), err)
+	}
+	if err := run(cfg); err != nil {
+		t.Errorf("run against the regenerated goldens: %s", err)
+	}
+}
+
+// TestMetadataWithoutHours checks that a canteen page without opening hours
+// leaves them unknown instead of closed all week
+func TestMetadataWithoutHours(t *testing.T) {
+	site := newTestSite(t, "1")
+	page := strings.Replace(site.metaPage("1"), "<div>Mo. – So.\n 11:00 – 14:30 Uhr</div>", "", 1)
+	noHours := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, page)
+	}))
+	defer noHours.Close()
+
+	for _, test := range []struct {
+		url   string
+		times bool
+	}{
+		{site.URL + "/meta", true},
+		{noHours.URL, false},
+	} {
+		cfg := site.config(t)
+		cfg.MetaURL = test.url
+		c, err := NewParser(cfg).Metadata(context.Background(), "1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b strings.Builder
+		if err := c.Write(&b); err != nil {
+			t.Fatal(err)
+		}
+		if times := strings.Contains(b.String(), "<times"); times != test.times || (c.Times != nil) != test.times {
+			t.Errorf("%s: times %v, want %v:\n%s", test.url, times, test.times, b.String())
+		}
+		if strings.Contains(b.String(), "<closed") {
+			t.Errorf("%s: closed days in the metadata:\n%s", test.url, b.String())
+		}
+	}
+}
+
+// TestEuroSuffixedPrices checks that euro signs and (non-breaking) spaces
+// around each price leave the roles of the prices alone
+func TestEuroSuffixedPrices(t *testing.T) {
+	p := NewParser(defaultConfig())
+	for _, cell := range []string{
+		"1,95 € / 3,10 € / 4,65 €",
+		"1,95 € 3,10 € 4,65 €",
+		"€ 1,95 | € 3,10 | € 4,65",
+		"1,95€/3,10€/4,65€",
+		"   1,95 €   3,10 €  4,65 €  ",
+	} {
+		day := strings.Replace(testDay, "€ 1,95/3,10/4,65", cell, 1)
+		d := p.parseDay("1", "2026-10-14", parseDoc(t, day))
+		if len(d.Categories) != 1 || len(d.Categories[0].Meals) != 1 {
+			t.Fatalf("%q: parsed %+v", cell, d)
+		}
+		var got []string
+		for _, price := range d.Categories[0].Meals[0].Prices {
+			got = append(got, price.Role+"="+price.Price)
+		}
+		if want := "student=1.95 employee=3.10 other=4.65"; strings.Join(got, " ") != want {
+			t.Errorf("%q: prices %q, want %q", cell, got, want)
+		}
+	}
+}
+
+// TestTimesType checks that -times-type sets the type attribute of the
+// opening hours written to the metadata
+func TestTimesType(t *testing.T) {
+	for _, typ := range []string{"", "mealtime"} {
+		site := newTestSite(t, "1")
+		cfg := site.config(t)
+		if typ != "" {
+			cfg.TimesType = typ
+		}
+		if err := run(cfg); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(filepath.Join(cfg.OutputDir, "1", metadataFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := `<times type="opening">`
+		if typ != "" {
+			want = `<times type="` + typ + `">`
+		}
+		if bytes.Count(data, []byte("<times ")) != 1 || !bytes.Contains(data, []byte(want)) {
+			t.Errorf("metadata lacks %s:\n%s", want, data)
+		}
+	}
+	if got := string(mustMarshal(t, &Times{openingHours: make([]string, 7)})); !strings.HasPrefix(got, `<times type="opening">`) {
+		t.Errorf("times without type %s", got)
+	}
+}
+
+// mustMarshal returns the XML encoding of v
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	data, err := xml.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// TestRequestLogConcurrent checks that the request log of a concurrent run
+// has one intact line per request made
+func TestRequestLogConcurrent(t *testing.T) {
+	ids := []string{"1", "2", "3", "4", "5", "6", "7", "8"}
+	site := newTestSite(t, ids...)
+	cfg := site.config(t)
+	cfg.Concurrency = 4
+	cfg.DaysAfter = 2
+	cfg.RequestLog = filepath.Join(t.TempDir(), "requests.jsonl")
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(cfg.RequestLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	perId := make(map[string]int)
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	for _, line := range lines {
+		var r requestRecord
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("%q: %s", line, err)
+		}
+		if r.Status != http.StatusOK || r.Attempt != 1 {
+			t.Errorf("record %+v", r)
+		}
+		perId[r.Form.Get("resources_id")]++
+	}
+	if n := site.requests["/meta"] + site.requests["/day"]; len(lines) != n {
+		t.Errorf("%d lines for %d requests", len(lines), n)
+	}
+	// the metadata and three days of each canteen
+	for _, id := range ids {
+		if perId[id] != 4 {
+			t.Errorf("%s: %d requests logged, want 4", id, perId[id])
+		}
+	}
+}
+
+// TestProbeNewIcons checks that -probe-new-icons lists the distinct icons of
+// saved fixtures, each with the fixture it was first seen in
+func TestProbeNewIcons(t *testing.T) {
+	dir := t.TempDir()
+	icon := func(src string) string {
+		return strings.Replace(testDay, `<div class="text-right">`, `<img class="splIcon" src="`+src+`"><div class="text-right">`, 1)
+	}
+	for name, page := range map[string]string{
+		"1_2026-10-13": icon("/icons/1.png"),
+		"1_2026-10-14": icon("/icons/1.png"),
+		"2_2026-10-14": icon("/icons/saisonal.png"),
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name+".html"), []byte(page), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	report := filepath.Join(t.TempDir(), "icons.json")
+	cfg, err := parseFlags([]string{"-compare-golden", dir, "-update", "-output", t.TempDir(), "-probe-new-icons", report})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	var icons []iconSighting
+	readJSON(t, report, &icons)
+	want := []iconSighting{
+		{Src: "/icons/saisonal.png", Count: 1, Id: "2", Date: "2026-10-14"},
+		{Src: "/icons/1.png", Note: "vegetarisch", Count: 2, Id: "1", Date: "2026-10-13"},
+	}
+	if len(icons) != len(want) {
+		t.Fatalf("icons %+v, want %+v", icons, want)
+	}
+	for i := range want {
+		if icons[i] != want[i] {
+			t.Errorf("icon %d: %+v, want %+v", i, icons[i], want[i])
+		}
+	}
+}
+
+// TestDayDateFormat checks that a meal page only answering to DD.MM.YYYY
+// dates is fetched with that format, which is then kept for the canteen
+func TestDayDateFormat(t *testing.T) {
+	var mu sync.Mutex
+	var dates []string
+	day := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		date := r.FormValue("date")
+		mu.Lock()
+		dates = append(dates, date)
+		mu.Unlock()
+		if _, err := time.Parse("02.01.2006", date); err != nil {
+			fmt.Fprint(w, `<html><body></body></html>`)
+			return
+		}
+		fmt.Fprint(w, testDay)
+	}))
+	defer day.Close()
+
+	site := newTestSite(t, "1")
+	cfg := site.config(t)
+	cfg.MealURL = day.URL
+	cfg.DaysAfter = 2
+	p := NewParser(cfg)
+	p.anchor = time.Date(2026, 10, 14, 12, 0, 0, 0, time.Local)
+	var err error
+	out := captureLog(t, func() { err = runParser(p) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "2026-10-14 14.10.2026 15.10.2026 16.10.2026"; strings.Join(dates, " ") != want {
+		t.Errorf("dates %q, want %s", dates, want)
+	}
+	if !strings.Contains(out, "only answers to date format 02.01.2006") {
+		t.Errorf("fallback not logged:\n%s", out)
+	}
+	feed, err := os.ReadFile(filepath.Join(cfg.OutputDir, "1", fullFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(feed, []byte("<name>Schnitzel</name>")); n != 3 {
+		t.Errorf("%d days with meals, want 3:\n%s", n, feed)
+	}
+}
+
+// TestMinMeals checks that -min-meals warns about open days with fewer
+// meals only, not about closed or empty days nor without known hours
+func TestMinMeals(t *testing.T) {
+	meals := func(n int) []Category {
+		c := Category{Name: "Essen"}
+		for i := 0; i < n; i++ {
+			c.Meals = append(c.Meals, Meal{Name: fmt.Sprint("Essen ", i)})
+		}
+		return []Category{c}
+	}
+	days := []Day{
+		{Date: "2026-10-14", Categories: meals(1)}, // Wednesday
+		{Date: "2026-10-15", Categories: meals(2)},
+		{Date: "2026-10-16", Categories: meals(0)},
+		{Date: "2026-10-17", Categories: meals(1)}, // Saturday, closed
+	}
+	weekdays := &Times{openingHours: []string{"11:00-14:30", "11:00-14:30", "11:00-14:30", "11:00-14:30", "11:00-14:30", "", ""}}
+	for _, test := range []struct {
+		hours *Times
+		want  []string
+	}{
+		{weekdays, []string{"1: 2026-10-14: only 1 meals on an open day, expected at least 2"}},
+		{nil, nil},
+	} {
+		p := NewParser(defaultConfig())
+		out := captureLog(t, func() { p.checkMealCount("1", days, test.hours, 2) })
+		if n := p.warnings.len(); n != len(test.want) {
+			t.Errorf("%d warnings, want %d:\n%s", n, len(test.want), out)
+		}
+		for _, want := range test.want {
+			if !strings.Contains(out, want) {
+				t.Errorf("missing warning %q:\n%s", want, out)
+			}
+		}
+	}
+
+	// the canteens of a testSite are open daily and serve one meal
+	for min, fails := range map[int]bool{1: false, 2: true} {
+		site := newTestSite(t, "1")
+		cfg := site.config(t)
+		cfg.MinMeals = min
+		cfg.Strict = true
+		if err := run(cfg); (err != nil) != fails {
+			t.Errorf("-min-meals %d: run error %v", min, err)
+		}
+	}
+}
+
+// TestVerifyUrlsReported checks that -verify-urls logs the broken feed URLs
+// of the layout in use, unreachable ones included
+func TestVerifyUrlsReported(t *testing.T) {
+	var mu sync.Mutex
+	var heads []string
+	published := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		heads = append(heads, r.URL.Path)
+		if r.URL.Path != "/1-"+fullFile {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer published.Close()
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+
+	for _, test := range []struct {
+		base string
+		want []string
+	}{
+		{published.URL + "/", []string{"2: verify: " + published.URL + "/2-full.xml: got status code 404"}},
+		{unreachable.URL + "/", []string{"1: verify: ", "2: verify: "}},
+	} {
+		site := newTestSite(t, "1", "2")
+		cfg := site.config(t)
+		cfg.OutputLayout = "flat"
+		cfg.FeedBase = test.base
+		cfg.VerifyURLs = true
+		var err error
+		out := captureLog(t, func() { err = run(cfg) })
+		if err == nil {
+			t.Errorf("%s: run with broken feed URLs succeeded", test.base)
+		}
+		for _, want := range test.want {
+			if !strings.Contains(out, want) {
+				t.Errorf("%s: %q not logged:\n%s", test.base, want, out)
+			}
+		}
+	}
+	if got := strings.Join(heads, " "); got != "/1-full.xml /2-full.xml" {
+		t.Errorf("checked %s", got)
+	}
+}
+
+// TestTransitStops checks the stops of a canteen listing several under more
+// than one label, and that a canteen without any has no transit entry
+func TestTransitStops(t *testing.T) {
+	site := newTestSite(t, "1", "2")
+	site.info["1"] = `<ul><li>Haltestellen: U2 Zoologischer Garten und S Zoologischer Garten</li>
+<li>ÖPNV: Bus 245; Bus X34 / U2 Zoologischer Garten.</li></ul>`
+	cfg := site.config(t)
+	cfg.Catalog = true
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	var entries []map[string]interface{}
+	readJSON(t, filepath.Join(cfg.OutputDir, catalogFile), &entries)
+	if len(entries) != 2 {
+		t.Fatalf("%d entries", len(entries))
+	}
+	want := []interface{}{"U2 Zoologischer Garten", "S Zoologischer Garten", "Bus 245", "Bus X34"}
+	if got, _ := entries[0]["transit"].([]interface{}); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("stops of 1 %v, want %v", entries[0]["transit"], want)
+	}
+	if stops, ok := entries[1]["transit"]; ok {
+		t.Errorf("stops of 2 %v, want none", stops)
+	}
+}
+
+// TestOpeningHoursPadded checks that opening hours with single-digit hours
+// come out as canonical "HH:MM-HH:MM"
+func TestOpeningHoursPadded(t *testing.T) {
+	site := newTestSite(t, "1")
+	site.info["1"] = `<div>Mo. – Fr.
+ 8:30 – 9:45 Uhr</div>`
+	p := NewParser(site.config(t))
+	c, err := p.Metadata(context.Background(), "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"08:30-09:45", "08:30-09:45", "08:30-09:45", "08:30-09:45", "08:30-09:45", "11:00-14:30", "11:00-14:30"}
+	if c.Times == nil || strings.Join(c.Times.openingHours, " ") != strings.Join(want, " ") {
+		t.Fatalf("opening hours %+v, want %v", c.Times, want)
+	}
+	if data := mustMarshal(t, c.Times); !bytes.Contains(data, []byte(`<monday open="08:30-09:45"></monday>`)) {
+		t.Errorf("times %s", data)
+	}
+
+	for s, want := range map[string]string{"8:30": "08:30", "08:30": "08:30", "14:00": "14:00"} {
+		if got := clockTime(s); got != want {
+			t.Errorf("clockTime(%q) = %q, want %q", s, got, want)
+		}
+	}
+}
+
+// TestListboxVariants checks that the listing is found by the id or the
+// class of its listbox alone, and which selector matched is logged
+func TestListboxVariants(t *testing.T) {
+	for _, test := range []struct {
+		attrs    string
+		selector string
+	}{
+		{`id="listboxEinrichtungen" class="listboxStandorte"`, "select#listboxEinrichtungen.listboxStandorte"},
+		{`id="listboxEinrichtungen" class="form-control"`, "select#listboxEinrichtungen"},
+		{`id="standorte" class="listboxStandorte"`, "select.listboxStandorte"},
+	} {
+		listing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `<html><body><select id="sprache"><option value="de">Deutsch</option></select>
+<select %s><option value="321">Mensa Nord</option><option value="322">Mensa Süd</option></select></body></html>`, test.attrs)
+		}))
+		cfg := defaultConfig()
+		cfg.MetaURL = listing.URL
+		var ids []string
+		var err error
+		out := captureLog(t, func() { ids, err = NewParser(cfg).FetchIds(context.Background()) })
+		listing.Close()
+		if err != nil || strings.Join(ids, " ") != "321 322" {
+			t.Errorf("%s: ids %q, %v", test.attrs, ids, err)
+		}
+		if !strings.Contains(out, "listing: using `"+test.selector+"`") {
+			t.Errorf("%s: selector not logged:\n%s", test.attrs, out)
+		}
+	}
+}
+
+// TestIgnoreNotes checks that the notes given with -ignore-notes, inline or
+// from a file, are stripped from the feed while the others survive
+func TestIgnoreNotes(t *testing.T) {
+	day := strings.Replace(testDay, `<div class="text-right">`, `<img class="splIcon" src="/icons/15.png">`+
+		`<div class="kennz"><table><tr><td>enthält Schweinefleisch</td><td>Knoblauch</td><td>Sellerie</td></tr></table></div>`+
+		`<div class="text-right">`, 1)
+	list := filepath.Join(t.TempDir(), "notes.txt")
+	if err := os.WriteFile(list, []byte("enthält Schweinefleisch\n\nSellerie\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		args []string
+		want string
+	}{
+		{nil, "vegan, enthält Schweinefleisch, Knoblauch, Sellerie"},
+		{[]string{"-ignore-notes", "enthält Schweinefleisch,Sellerie"}, "vegan, Knoblauch"},
+		{[]string{"-ignore-notes", "@" + list}, "vegan, Knoblauch"},
+		{[]string{"-ignore-notes", "vegan", "-ignore-notes", "Knoblauch"}, "enthält Schweinefleisch, Sellerie"},
+	} {
+		site := newTestSite(t, "1")
+		site.day = day
+		cfg, err := parseFlags(test.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		base := site.config(t)
+		base.IgnoreNotes = cfg.IgnoreNotes
+		if err := run(base); err != nil {
+			t.Fatal(err)
+		}
+		feed, err := os.ReadFile(filepath.Join(base.OutputDir, "1", fullFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var notes []string
+		for _, m := range regexp.MustCompile(`<note>(.*?)</note>`).FindAllSubmatch(feed, -1) {
+			notes = append(notes, string(m[1]))
+		}
+		if strings.Join(notes, ", ") != test.want {
+			t.Errorf("%q: notes %q, want %s", test.args, notes, test.want)
+		}
+	}
+}
+
+// TestSingleCategoryName checks that -single-category-name renames the only
+// served category of a day and leaves days with several alone
+func TestSingleCategoryName(t *testing.T) {
+	single := strings.Replace(testDay, ">Essen<", ">Angebote<", 1)
+	withEmpty := strings.Replace(single, "</body>", `<div class="splGroupWrapper"><div class="splGroup">Aktionen</div></div></body>`, 1)
+	for _, test := range []struct {
+		day    string
+		rename string
+		want   string // category names joined by " "
+	}{
+		{single, "", "Angebote"},
+		{single, "Speisen", "Speisen"},
+		{withEmpty, "Speisen", "Speisen"},
+		{testTwoCategories, "Speisen", "Essen Desserts"},
+	} {
+		site := newTestSite(t, "1")
+		site.day = test.day
+		cfg := site.config(t)
+		cfg.SingleCategoryName = test.rename
+		if err := run(cfg); err != nil {
+			t.Fatal(err)
+		}
+		feed, err := os.ReadFile(filepath.Join(cfg.OutputDir, "1", fullFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, m := range regexp.MustCompile(`<category name="(.*?)">`).FindAllSubmatch(feed, -1) {
+			names = append(names, string(m[1]))
+		}
+		if strings.Join(names, " ") != test.want {
+			t.Errorf("%q: categories %q, want %s", test.rename, names, test.want)
+		}
+	}
+}
+
+// TestMetadataEmail checks the email of the metadata taken from a mailto
+// link, from obfuscated text, and that an invalid address is left out
+func TestMetadataEmail(t *testing.T) {
+	block := `<div><i class="glyphicon glyphicon-envelope"></i></div><div>%s</div>`
+	for _, test := range []struct {
+		html string
+		want string
+		warn bool
+	}{
+		{`<a href="mailto:mensa.nord@stw.berlin"><span>mensa.nord</span><span>(at)</span><span>stw.berlin</span></a>`, "mensa.nord@stw.berlin", false},
+		{`<span>mensa.sued</span> (at) <span>stw.berlin</span>`, "mensa.sued@stw.berlin", false},
+		{`<span>Kontakt über das Formular</span>`, "", true},
+	} {
+		site := newTestSite(t, "1")
+		site.info["1"] = fmt.Sprintf(block, test.html)
+		p := NewParser(site.config(t))
+		c, err := p.Metadata(context.Background(), "1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Email != test.want || (p.warnings.len() > 0) != test.warn {
+			t.Errorf("%s: email %q with %d warnings, want %q", test.html, c.Email, p.warnings.len(), test.want)
+		}
+		data := mustMarshal(t, c)
+		if has := bytes.Contains(data, []byte("<email>"+test.want+"</email>")); has != (test.want != "") {
+			t.Errorf("%s: metadata %s", test.html, data)
+		}
+	}
+}
+
+// TestOpeningHoursWrapping checks that a day range like "Fr. – Mo." wraps
+// the week
+func TestOpeningHoursWrapping(t *testing.T) {
+	site := newTestSite(t, "1")
+	// the regular block of the site says Mo. – So. 11:00 – 14:30
+	site.info["1"] = `<div>Fr. – Mo.
+ 10:00 – 12:00 Uhr</div>`
+	p := NewParser(site.config(t))
+	var c *Canteen
+	var err error
+	out := captureLog(t, func() { c, err = p.Metadata(context.Background(), "1") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10:00-12:00", "11:00-14:30", "11:00-14:30", "11:00-14:30", "10:00-12:00", "10:00-12:00", "10:00-12:00"}
+	if c.Times == nil || strings.Join(c.Times.openingHours, " ") != strings.Join(want, " ") {
+		t.Errorf("opening hours %+v, want %v", c.Times, want)
+	}
+	if !strings.Contains(out, "wraps around the weekend") {
+		t.Errorf("wrap not logged:\n%s", out)
+	}
+}
+
+// TestPreOrderMeals checks that meals marked for pre-order only, by a note
+// or in their name, get the pre-order note once
+func TestPreOrderMeals(t *testing.T) {
+	meal := `<div class="splMeal"><span class="bold">%s</span>%s<div class="text-right">€ 1,95/3,10/4,65</div></div>`
+	kennz := `<div class="kennz"><table><tr><td>%s</td></tr></table></div>`
+	site := newTestSite(t, "1")
+	site.day = `<html><body><div class="splGroupWrapper"><div class="splGroup">Essen</div>` +
+		fmt.Sprintf(meal, "Ente", fmt.Sprintf(kennz, "nur auf Vorbestellung")) +
+		fmt.Sprintf(meal, "Gans (bitte vorbestellen)", fmt.Sprintf(kennz, "Vorbestellung bis 10 Uhr")) +
+		fmt.Sprintf(meal, "Schnitzel", fmt.Sprintf(kennz, "Knoblauch")) +
+		`</div></body></html>`
+	cfg := site.config(t)
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	feed, err := os.ReadFile(filepath.Join(cfg.OutputDir, "1", fullFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"<name>Ente</name>\n          <note>Vorbestellung erforderlich</note>\n          <price",
+		"<name>Gans (bitte vorbestellen)</name>\n          <note>Vorbestellung erforderlich</note>\n          <price",
+		"<name>Schnitzel</name>\n          <note>Knoblauch</note>\n          <price",
+	} {
+		if !bytes.Contains(feed, []byte(want)) {
+			t.Errorf("feed lacks %q:\n%s", want, feed)
+		}
+	}
+}
+
+// errReader fails every read after its content
+type errReader struct{ r io.Reader }
+
+func (e errReader) Read(b []byte) (int, error) {
+	if n, _ := e.r.Read(b); n > 0 {
+		return n, nil
+	}
+	return 0, errors.New("connection reset by peer")
+}
+
+// TestCorruptBody checks that a body whose read fails is retried and that
+// the fetch fails, without a panic, once the retries are used up
+func TestCorruptBody(t *testing.T) {
+	for _, good := range []bool{false, true} {
+		p := NewParser(defaultConfig())
+		p.cfg.RetryStep, p.cfg.RetryMax = time.Millisecond, time.Millisecond
+		var attempts int
+		p.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			attempts++
+			var body io.Reader = errReader{strings.NewReader("<html><body><div")}
+			if good && attempts == 3 {
+				body = strings.NewReader(testDay)
+			}
+			return &http.Response{
+				StatusCode:    http.StatusOK,
+				Header:        http.Header{"Content-Type": {"text/html"}},
+				Body:          io.NopCloser(body),
+				ContentLength: -1,
+				Request:       r,
+			}, nil
+		})
+		doc, err := p.getHttpDocRetries(context.Background(), "http://mensa.example/day", nil, 3)
+		if attempts != 3 {
+			t.Errorf("%d attempts, want 3", attempts)
+		}
+		if good && (err != nil || doc.Find("div.splMeal").Length() != 1) {
+			t.Errorf("fetch after the corrupt bodies: %v", err)
+		}
+		if !good && err == nil {
+			t.Error("fetch of corrupt bodies only succeeded")
+		}
+	}
+}
+
+// TestSemesterBreakHours checks that the break hours following the regular
+// ones end up in the note, not in the opening hours
+func TestSemesterBreakHours(t *testing.T) {
+	site := newTestSite(t, "1")
+	site.info["1"] = `<div>Öffnungszeiten abweichend in den Semesterferien (15.07.2026 – 14.10.2026): Mo. – Fr.
+ 11:30 – 13:30 Uhr</div>`
+	p := NewParser(site.config(t))
+	c, err := p.Metadata(context.Background(), "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &SemesterBreak{Note: "Öffnungszeiten abweichend in den Semesterferien (15.07.2026 – 14.10.2026): Mo. – Fr. 11:30 – 13:30 Uhr", From: "2026-07-15", To: "2026-10-14"}
+	if c.SemesterBreak == nil || *c.SemesterBreak != *want {
+		t.Errorf("semester break %+v, want %+v", c.SemesterBreak, want)
+	}
+	if c.Times == nil || strings.Join(c.Times.openingHours, " ") != strings.Repeat("11:00-14:30 ", 6)+"11:00-14:30" {
+		t.Errorf("opening hours %+v", c.Times)
+	}
+}
+
+// TestSingleCanteenPage checks that the name of a page without listbox is
+// taken from its heading, without fetching the direct link
+func TestSingleCanteenPage(t *testing.T) {
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+		fmt.Fprint(w, `<html><head><title>studierendenWERK BERLIN - Falscher Name</title></head><body>
+<h2>Mensa Nord</h2>
+<div id="directlink">/direct</div>
+<div><div><i class="glyphicon glyphicon-time"></i></div></div>
+<div>Mo. – Fr.
+ 11:00 – 14:30 Uhr</div>
+</body></html>`)
+	}))
+	defer srv.Close()
+
+	cfg := defaultConfig()
+	cfg.MetaURL = srv.URL + "/meta"
+	var c *Canteen
+	out := captureLog(t, func() {
+		var err error
+		if c, err = NewParser(cfg).Metadata(context.Background(), "1"); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if c.Name != "Mensa Nord" {
+		t.Errorf("name %q, want Mensa Nord", c.Name)
+	}
+	if !strings.Contains(out, "determined from heading") {
+		t.Errorf("log without the heading method:\n%s", out)
+	}
+	if fmt.Sprint(requests) != "[/meta]" {
+		t.Errorf("requests %v, want only the metadata page", requests)
+	}
+}
+
+// TestCatalogOperator checks that an external operator makes it into the
+// catalog and the studierendenWERK itself does not
+func TestCatalogOperator(t *testing.T) {
+	site := newTestSite(t, "1", "2")
+	site.info["1"] = `<p>Betreiber: Kochwerk GmbH.</p>`
+	site.info["2"] = `<p>Betreiber: studierendenWERK BERLIN</p>`
+	cfg := site.config(t)
+	cfg.Catalog = true
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	var entries []map[string]interface{}
+	readJSON(t, filepath.Join(cfg.OutputDir, catalogFile), &entries)
+	if len(entries) != 2 {
+		t.Fatalf("%d entries", len(entries))
+	}
+	if got := entries[0]["operator"]; got != "Kochwerk GmbH" {
+		t.Errorf("operator of 1 %v, want Kochwerk GmbH", got)
+	}
+	if got, ok := entries[1]["operator"]; ok {
+		t.Errorf("operator of 2 %v, want none", got)
+	}
+}
+
+// TestFootnotedMealName checks that a meal name wrapped in a link and laden
+// with footnote markers comes out clean, with the markers as notes
+func TestFootnotedMealName(t *testing.T) {
+	page := strings.Replace(testDay, `<span class="bold">Schnitzel</span>`,
+		`<span class="bold"><a href="#info"><span>Schnitzel</span><sup>1,a</sup> <span class="fussnote">(G)</span></a></span>`, 1)
+	page = strings.Replace(page, `<div class="text-right">`, `<img class="splIcon" src="/vital/images/1.png"><div class="text-right">`, 1)
+	p := NewParser(defaultConfig())
+	d := p.parseDay("1", "2026-10-14", parseDoc(t, page))
+	meal := d.Categories[0].Meals[0]
+	if meal.Name != "Schnitzel" {
+		t.Errorf("name %q, want Schnitzel", meal.Name)
+	}
+	var notes []string
+	for _, n := range meal.Notes {
+		notes = append(notes, string(n))
+	}
+	if want := "vegetarisch, 1, a, G"; strings.Join(notes, ", ") != want {
+		t.Errorf("notes %v, want %s", notes, want)
+	}
+}
+
+// TestRelativeDirectlink checks that a relative directlink is resolved
+// against the metadata URL and the name taken from the linked page
+func TestRelativeDirectlink(t *testing.T) {
+	for link, want := range map[string]string{
+		"/mensen/nord.html":     "/mensen/nord.html",
+		"nord.html":             "/speiseplan/nord.html",
+		" ../mensen/nord.html ": "/mensen/nord.html",
+	} {
+		var requests []string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests = append(requests, r.URL.Path)
+			if r.URL.Path == want {
+				fmt.Fprint(w, `<html><head><title>studierendenWERK BERLIN - Mensa Nord</title></head></html>`)
+				return
+			}
+			fmt.Fprint(w, `<html><body><select id="listboxEinrichtungen"><option value="1">Mensa 1</option></select>
+<div id="directlink">`+link+`</div></body></html>`)
+		}))
+
+		cfg := defaultConfig()
+		cfg.MetaURL = srv.URL + "/speiseplan/meta"
+		c, err := NewParser(cfg).Metadata(context.Background(), "1")
+		srv.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Name != "Mensa Nord" {
+			t.Errorf("directlink %q: name %q, want Mensa Nord", link, c.Name)
+		}
+		if got := fmt.Sprint(requests); got != "[/speiseplan/meta "+want+"]" {
+			t.Errorf("directlink %q: requests %s, want %s resolved", link, got, want)
+		}
+	}
+}
+
+// TestConflictingAmpel checks that a meal with several ampel icons keeps the
+// most severe one, whatever their order, with a warning
+func TestConflictingAmpel(t *testing.T) {
+	icon := func(src string) string { return `<img class="splIcon" src="/vital/images/` + src + `">` }
+	for _, icons := range []string{
+		icon("ampel_gruen_70x65.png") + icon("1.png") + icon("ampel_rot_70x65.png"),
+		icon("ampel_rot_70x65.png") + icon("1.png") + icon("ampel_gelb_70x65.png") + icon("ampel_gruen_70x65.png"),
+	} {
+		page := strings.Replace(testDay, `<div class="text-right">`, icons+`<div class="text-right">`, 1)
+		p := NewParser(defaultConfig())
+		var d Day
+		out := captureLog(t, func() { d = p.parseDay("1", "2026-10-14", parseDoc(t, page)) })
+		var notes []string
+		for _, n := range d.Categories[0].Meals[0].Notes {
+			notes = append(notes, string(n))
+		}
+		if want := "rot (Ampel), vegetarisch"; strings.Join(notes, ", ") != want {
+			t.Errorf("%s: notes %v, want %s", icons, notes, want)
+		}
+		if !strings.Contains(out, "different ampel icons, keeping the most severe") {
+			t.Errorf("%s: no warning in\n%s", icons, out)
+		}
+	}
+
+	page := strings.Replace(testDay, `<div class="text-right">`, icon("ampel_gelb_70x65.png")+icon("ampel_gelb_70x65.png")+`<div class="text-right">`, 1)
+	p := NewParser(defaultConfig())
+	if out := captureLog(t, func() { p.parseDay("1", "2026-10-14", parseDoc(t, page)) }); strings.Contains(out, "ampel") {
+		t.Errorf("warning about a repeated icon:\n%s", out)
+	}
+}
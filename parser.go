@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Parser fetches and parses the canteens of one site and writes the output
+// of a run. All state of a run lives here, the configuration, the sink and
+// the reports included, so that several parsers can run at the same time.
+type Parser struct {
+	cfg    Config
+	anchor time.Time // all dates of the run are computed relative to its day
+	client *http.Client
+	cache  *httpCache // nil unless cfg.HTTPCache is set
+	roles  *roleTable
+	// jitter returns a random number in [0, n); tests seed it for
+	// deterministic backoff
+	jitter func(n int64) int64
+
+	// output receives the generated files
+	output OutputSink
+	// state holds the state files written to an archive output by name, to
+	// be kept in OutputDir as well once the archive is published
+	state map[string][]byte
+
+	// errors and warnings of the run; the other reports are nil unless
+	// configured
+	errors     *errorReport
+	warnings   *warningList
+	icons      *iconProbe
+	requestLog *requestLogger
+	tracer     *spanTracer
+
+	// requests counts the HTTP requests issued, capped by cfg.MaxRequests
+	requests int64
+
+	// retries, throttled (509 responses) and backoff (nanoseconds slept)
+	// show how much the site throttled the run
+	retries, throttled, backoff int64
+
+	// dayFormats remembers per canteen id the index of the dayDateFormats
+	// entry that yielded a non-empty page
+	dayFormats sync.Map
+}
+
+// NewParser returns a Parser for cfg; its fetching methods take the context
+// that cancels their requests and backoff sleeps
+func NewParser(cfg Config) *Parser {
+	var cache *httpCache
+	if cfg.HTTPCache != "" {
+		cache = &httpCache{dir: cfg.HTTPCache, mode: cfg.OutputMode}
+	}
+	return &Parser{
+		cfg:    cfg,
+		anchor: time.Now(),
+		client: newHttpClient(cfg.HTTPTimeout),
+		cache:  cache,
+		roles:  newRoleTable(cfg.PriceRoleMap, cfg.PriceCounts),
+		jitter: newJitter(rand.NewSource(time.Now().UnixNano())),
+		output: fsSink{dir: cfg.OutputDir, mode: cfg.OutputMode},
+
+		errors:   &errorReport{},
+		warnings: &warningList{},
+	}
+}
+
+// newJitter returns a jitter func drawing from src, which it guards for the
+// workers of a run
+func newJitter(src rand.Source) func(n int64) int64 {
+	var mu sync.Mutex
+	r := rand.New(src)
+	return func(n int64) int64 {
+		mu.Lock()
+		defer mu.Unlock()
+		return r.Int63n(n)
+	}
+}
+
+// newHttpClient returns the client of a Parser: all requests go to the same
+// host, so idle connections are kept for reuse; timeout bounds a whole
+// exchange including the body, 0 disables it
+func newHttpClient(timeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = 32
+	transport.MaxIdleConnsPerHost = 16
+	transport.IdleConnTimeout = 90 * time.Second
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// retrySummary reports the retries spent so far, for the end of the run
+func (p *Parser) retrySummary() string {
+	backoff := time.Duration(atomic.LoadInt64(&p.backoff))
+	return fmt.Sprintf("retries: %d, %d of them after status 509, %s spent backing off",
+		atomic.LoadInt64(&p.retries), atomic.LoadInt64(&p.throttled), backoff)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+)
+
+// forEachId calls fn for every id from n goroutines at once. A panic in fn
+// only fails its canteen, it is recorded in errs like other errors of single
+// canteens. An error returned by fn aborts the run: no further ids are
+// started and the first such error is returned once the running calls are
+// done.
+func forEachId(ids []string, n int, errs *errorReport, fn func(id string) error) error {
+	if n < 1 {
+		n = 1
+	}
+
+	var (
+		mu    sync.Mutex
+		first error
+	)
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return first != nil
+	}
+
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range jobs {
+				if err := safeCall(id, errs, fn); err != nil {
+					mu.Lock()
+					if first == nil {
+						first = err
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, id := range ids {
+		if failed() {
+			break
+		}
+		jobs <- id
+	}
+	close(jobs)
+	wg.Wait()
+	return first
+}
+
+// safeCall calls fn(id), turning a panic into an error of the canteen
+func safeCall(id string, errs *errorReport, fn func(id string) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("%s: panic: %v\n%s", id, r, debug.Stack())
+			errs.add(id, "panic", fmt.Errorf("panic: %v", r), false)
+			err = nil
+		}
+	}()
+	return fn(id)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RunError is a failure encountered during a run
+type RunError struct {
+	Id      string `json:"id,omitempty"`
+	Phase   string `json:"phase"`
+	Message string `json:"message"`
+	Fatal   bool   `json:"fatal"`
+}
+
+type errorReport struct {
+	mu     sync.Mutex
+	errors []RunError
+}
+
+func (r *errorReport) add(id, phase string, err error, fatal bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errors = append(r.errors, RunError{Id: id, Phase: phase, Message: err.Error(), Fatal: fatal})
+}
+
+func (r *errorReport) len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.errors)
+}
+
+// ids returns the canteens with errors
+func (r *errorReport) ids() map[string]bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ids := make(map[string]bool)
+	for _, e := range r.errors {
+		if e.Id != "" {
+			ids[e.Id] = true
+		}
+	}
+	return ids
+}
+
+// write stores the collected errors as a JSON array in filename
+func (r *errorReport) write(filename string, mode fileMode) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	log.Println("generate", filename, "(errors report)")
+	errs := r.errors
+	if errs == nil {
+		errs = []RunError{}
+	}
+	data, err := json.MarshalIndent(errs, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filename, append(data, '\n'), mode)
+}
+
+// requestRecord is one line of the request log
+type requestRecord struct {
+	Time     time.Time  `json:"time"`
+	Method   string     `json:"method"`
+	Url      string     `json:"url"`
+	Form     url.Values `json:"form,omitempty"`
+	Attempt  int        `json:"attempt"`
+	Status   int        `json:"status,omitempty"`
+	Duration string     `json:"duration"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// requestLogger writes a JSON line per HTTP request; it is safe for
+// concurrent use and a nil *requestLogger discards everything
+type requestLogger struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func newRequestLogger(w io.Writer) *requestLogger {
+	return &requestLogger{enc: json.NewEncoder(w)}
+}
+
+func (l *requestLogger) log(start time.Time, method, url string, form url.Values, attempt int, resp *http.Response, err error) {
+	if l == nil {
+		return
+	}
+	r := requestRecord{
+		Time:     start,
+		Method:   method,
+		Url:      url,
+		Form:     form,
+		Attempt:  attempt,
+		Duration: time.Since(start).String(),
+	}
+	if resp != nil {
+		r.Status = resp.StatusCode
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.enc.Encode(r); err != nil {
+		log.Println("request log:", err)
+	}
+}
+
+// ParseWarning is a recoverable oddity encountered while parsing a page,
+// e.g. an unknown icon or an unexpected number of prices
+type ParseWarning string
+
+type warningList struct {
+	mu       sync.Mutex
+	warnings []ParseWarning
+}
+
+// warnf logs a parse warning like log.Printf and records it
+func (p *Parser) warnf(format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	log.Print(msg)
+
+	l := p.warnings
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.warnings = append(l.warnings, ParseWarning(strings.TrimSuffix(msg, "\n")))
+}
+
+func (l *warningList) len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.warnings)
+}
+
+// iconSighting describes an icon src seen during the run
+type iconSighting struct {
+	Src   string `json:"src"`
+	Note  Note   `json:"note,omitempty"` // empty for unknown icons
+	Count int    `json:"count"`
+	// first occurrence
+	Id   string `json:"id"`
+	Date string `json:"date"`
+}
+
+// iconProbe collects all distinct meal icons; it is safe for concurrent use
+// and a nil *iconProbe discards everything
+type iconProbe struct {
+	mu    sync.Mutex
+	icons map[string]*iconSighting
+}
+
+func newIconProbe() *iconProbe {
+	return &iconProbe{icons: make(map[string]*iconSighting)}
+}
+
+func (p *iconProbe) see(src, id, date string, note Note) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if icon, ok := p.icons[src]; ok {
+		icon.Count++
+		return
+	}
+	p.icons[src] = &iconSighting{Src: src, Note: note, Count: 1, Id: id, Date: date}
+}
+
+// write stores the sightings as JSON array in filename, unknown icons first
+func (p *iconProbe) write(filename string, mode fileMode) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	log.Println("generate", filename, "(icon report)")
+	icons := make([]*iconSighting, 0, len(p.icons))
+	for _, icon := range p.icons {
+		icons = append(icons, icon)
+	}
+	sort.Slice(icons, func(i, j int) bool {
+		if (icons[i].Note == "") != (icons[j].Note == "") {
+			return icons[i].Note == ""
+		}
+		return icons[i].Src < icons[j].Src
+	})
+	data, err := json.MarshalIndent(icons, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filename, append(data, '\n'), mode)
+}
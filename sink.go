@@ -0,0 +1,110 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// OutputSink receives the generated files; path is slash-separated and
+// relative to the root of the output
+type OutputSink interface {
+	Write(path string, r io.Reader) error
+}
+
+// fsSink writes below a local directory, replacing each file atomically
+type fsSink struct {
+	dir  string
+	mode fileMode // see Config.OutputMode
+}
+
+func (s fsSink) Write(path string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	filename := filepath.Join(s.dir, filepath.FromSlash(path))
+	if err := mkdirAll(filepath.Dir(filename), s.mode); err != nil {
+		return err
+	}
+	return writeFileAtomic(filename, data, s.mode)
+}
+
+// perm returns the mode of newly generated files, by default the one
+// os.Create would give them
+func (m fileMode) perm() os.FileMode {
+	if m != 0 {
+		return os.FileMode(m)
+	}
+	createPermOnce.Do(func() { createPerm = probeCreatePerm() })
+	return createPerm
+}
+
+var (
+	createPermOnce sync.Once
+	createPerm     os.FileMode
+)
+
+// probeCreatePerm returns 0666 minus the umask; the umask can only be read
+// by changing it, which would race with the files created meanwhile, so a
+// scratch file is created instead
+func probeCreatePerm() os.FileMode {
+	f, err := os.CreateTemp("", ".umask.*")
+	if err != nil {
+		return 0644
+	}
+	name := f.Name()
+	f.Close()
+	os.Remove(name)
+
+	// CreateTemp uses 0600, a file of its own name gets 0666 minus the umask
+	f, err = os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return 0644
+	}
+	defer os.Remove(name)
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return 0644
+	}
+	return fi.Mode().Perm()
+}
+
+// openFile opens filename for writing, creating it like the generated files
+// with mode m (see Config.OutputMode); flag adds e.g. os.O_TRUNC or
+// os.O_APPEND
+func openFile(filename string, flag int, m fileMode) (*os.File, error) {
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|flag, 0666)
+	if err != nil {
+		return nil, err
+	}
+	if m != 0 {
+		if err := file.Chmod(m.perm()); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return file, nil
+}
+
+// mkdirAll creates dir and its missing parents; with a configured mode
+// they get it plus x where readable regardless of the umask
+func mkdirAll(dir string, m fileMode) error {
+	if m == 0 {
+		return os.MkdirAll(dir, os.ModePerm)
+	}
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+	if err := mkdirAll(filepath.Dir(dir), m); err != nil {
+		return err
+	}
+	mode := m.perm()
+	mode |= mode & 0444 >> 2
+	if err := os.Mkdir(dir, mode); err != nil && !os.IsExist(err) {
+		return err
+	}
+	return os.Chmod(dir, mode)
+}
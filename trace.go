@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// spanRecord is one line of the trace, modeled after the OpenTelemetry span
+// JSON encoding so the file can be converted for existing trace viewers
+type spanRecord struct {
+	TraceId      string            `json:"traceId"`
+	SpanId       string            `json:"spanId"`
+	ParentSpanId string            `json:"parentSpanId,omitempty"`
+	Name         string            `json:"name"`
+	Start        int64             `json:"startTimeUnixNano"`
+	End          int64             `json:"endTimeUnixNano"`
+	Attributes   map[string]string `json:"attributes,omitempty"`
+}
+
+// spanTracer writes a JSON line per finished span and/or sends all spans to
+// an OTLP/HTTP collector when the run ends, all spans being children of the
+// span of the run; it is safe for concurrent use and a nil *spanTracer
+// discards everything
+type spanTracer struct {
+	mu      sync.Mutex
+	enc     *json.Encoder // nil without a trace file
+	otlp    string        // traces endpoint of the collector, if any
+	spans   []spanRecord  // finished spans not yet sent to otlp
+	traceId string
+	root    *span
+}
+
+// span is a started span, a nil *span is not recorded
+type span struct {
+	t   *spanTracer
+	rec spanRecord
+}
+
+// newSpanTracer returns a tracer writing to w and sending to the OTLP/HTTP
+// traces endpoint otlp ("http://localhost:4318/v1/traces"); either may be
+// unset
+func newSpanTracer(w io.Writer, otlp string) *spanTracer {
+	t := &spanTracer{otlp: otlp, traceId: randomId(16)}
+	if w != nil {
+		t.enc = json.NewEncoder(w)
+	}
+	t.root = t.start("run")
+	return t
+}
+
+// randomId returns n random bytes hex-encoded
+func randomId(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// start begins a span named name; attrs are alternating keys and values
+func (t *spanTracer) start(name string, attrs ...string) *span {
+	if t == nil {
+		return nil
+	}
+	s := &span{t: t, rec: spanRecord{
+		TraceId: t.traceId,
+		SpanId:  randomId(8),
+		Name:    name,
+		Start:   time.Now().UnixNano(),
+	}}
+	if t.root != nil {
+		s.rec.ParentSpanId = t.root.rec.SpanId
+	}
+	if len(attrs) > 0 {
+		s.rec.Attributes = make(map[string]string, len(attrs)/2)
+		for i := 0; i+1 < len(attrs); i += 2 {
+			s.rec.Attributes[attrs[i]] = attrs[i+1]
+		}
+	}
+	return s
+}
+
+// end finishes and records s; err, if any, is added as attribute
+func (s *span) end(err error) {
+	if s == nil {
+		return
+	}
+	s.rec.End = time.Now().UnixNano()
+	if err != nil {
+		if s.rec.Attributes == nil {
+			s.rec.Attributes = make(map[string]string)
+		}
+		s.rec.Attributes["error"] = err.Error()
+	}
+
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+	if s.t.otlp != "" {
+		s.t.spans = append(s.t.spans, s.rec)
+	}
+	if s.t.enc == nil {
+		return
+	}
+	if err := s.t.enc.Encode(s.rec); err != nil {
+		log.Println("trace:", err)
+	}
+}
+
+// close finishes the span of the run and sends the spans to the collector
+func (t *spanTracer) close() {
+	if t == nil {
+		return
+	}
+	t.root.end(nil)
+	if t.otlp == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := exportOTLP(t.otlp, t.spans); err != nil {
+		log.Println("trace:", err)
+	}
+	t.spans = nil
+}
+
+// otlpValue and the following types are the parts of the OTLP/HTTP JSON
+// encoding of traces in use
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"` // 1 ok, 2 error
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceId      string          `json:"traceId"`
+	SpanId       string          `json:"spanId"`
+	ParentSpanId string          `json:"parentSpanId,omitempty"`
+	Name         string          `json:"name"`
+	Kind         int             `json:"kind"` // 1 internal
+	Start        string          `json:"startTimeUnixNano"`
+	End          string          `json:"endTimeUnixNano"`
+	Attributes   []otlpAttribute `json:"attributes,omitempty"`
+	Status       otlpStatus      `json:"status"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+// exportOTLP posts spans to the OTLP/HTTP traces endpoint url as JSON
+func exportOTLP(url string, spans []spanRecord) error {
+	name := "openmensa-parser-berlin"
+	scope := otlpScopeSpans{Scope: otlpScope{name}}
+	for _, rec := range spans {
+		span := otlpSpan{
+			TraceId:      rec.TraceId,
+			SpanId:       rec.SpanId,
+			ParentSpanId: rec.ParentSpanId,
+			Name:         rec.Name,
+			Kind:         1,
+			Start:        strconv.FormatInt(rec.Start, 10),
+			End:          strconv.FormatInt(rec.End, 10),
+			Status:       otlpStatus{Code: 1},
+		}
+		keys := make([]string, 0, len(rec.Attributes))
+		for k := range rec.Attributes {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if k == "error" {
+				span.Status = otlpStatus{Code: 2, Message: rec.Attributes[k]}
+			}
+			span.Attributes = append(span.Attributes, otlpAttribute{Key: k, Value: otlpValue{rec.Attributes[k]}})
+		}
+		scope.Spans = append(scope.Spans, span)
+	}
+
+	body, err := json.Marshal(otlpTraces{[]otlpResourceSpans{{
+		Resource:   otlpResource{[]otlpAttribute{{Key: "service.name", Value: otlpValue{name}}}},
+		ScopeSpans: []otlpScopeSpans{scope},
+	}}})
+	if err != nil {
+		return err
+	}
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: got status code %d", url, resp.StatusCode)
+	}
+	return nil
+}
@@ -3,6 +3,9 @@ package main
 import (
 	"encoding/xml"
 	"io"
+	"sort"
+	"strings"
+	"time"
 )
 
 const (
@@ -11,6 +14,10 @@ const (
            xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"
            xsi:schemaLocation="http://openmensa.org/open-mensa-v2 http://openmensa.org/open-mensa-v2.xsd">` + "\n"
 	xmlFooter = "\n</openmensa>\n"
+
+	// xmlIndent is one level of indentation; the encoder output is
+	// prefixed with it as <canteen> is a child of <openmensa>
+	xmlIndent = "  "
 )
 
 type FeedSchedule struct {
@@ -48,6 +55,91 @@ type Meal struct {
 	Name    string   `xml:"name"`
 	Notes   []Note   `xml:"note"`
 	Prices  []Price
+	Image   string `xml:"-"` // URL of the dish photo, a note with -image-notes
+
+	compact bool // set by Canteen.Write, see MarshalXML
+}
+
+// priceRoleOrder is the canonical order of a meal's prices in the feed
+var priceRoleOrder = map[string]int{"student": 0, "pupil": 1, "employee": 2, "other": 3}
+
+// sortedPrices returns prices in canonical role order, keeping feeds stable
+// however the roles were assigned
+func sortedPrices(prices []Price) []Price {
+	sorted := append([]Price(nil), prices...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return priceRoleOrder[sorted[i].Role] < priceRoleOrder[sorted[j].Role]
+	})
+	return sorted
+}
+
+// MarshalXML writes m in the OpenMensa form or, with Canteen.CompactNotes, with all
+// notes joined by "; " into a single notes attribute; the compact form is not
+// valid OpenMensa and only meant for size-sensitive archives. prices are
+// written in canonical role order either way
+func (m Meal) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	start.Name = xml.Name{Local: "meal"}
+	m.Prices = sortedPrices(m.Prices)
+	if !m.compact {
+		type plain Meal
+		return e.EncodeElement(plain(m), start)
+	}
+
+	notes := make([]string, len(m.Notes))
+	for i, n := range m.Notes {
+		notes[i] = string(n)
+	}
+	return e.EncodeElement(struct {
+		XMLName xml.Name `xml:"meal"`
+		Notes   string   `xml:"notes,attr,omitempty"`
+		Name    string   `xml:"name"`
+		Prices  []Price
+	}{Notes: strings.Join(notes, "; "), Name: m.Name, Prices: m.Prices}, start)
+}
+
+// Equal reports whether m and o are the same meal; the order of notes and
+// prices is irrelevant
+func (m *Meal) Equal(o *Meal) bool {
+	if m.Name != o.Name || len(m.Notes) != len(o.Notes) || len(m.Prices) != len(o.Prices) {
+		return false
+	}
+
+	notes := func(notes []Note) []Note {
+		sorted := append([]Note(nil), notes...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		return sorted
+	}
+	a, b := notes(m.Notes), notes(o.Notes)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return samePrices(m.Prices, o.Prices)
+}
+
+// samePrices reports whether a and b hold the same prices in any order
+func samePrices(a, b []Price) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	prices := func(prices []Price) []Price {
+		sorted := append([]Price(nil), prices...)
+		sort.Slice(sorted, func(i, j int) bool {
+			if sorted[i].Role != sorted[j].Role {
+				return sorted[i].Role < sorted[j].Role
+			}
+			return sorted[i].Price < sorted[j].Price
+		})
+		return sorted
+	}
+	p, q := prices(a), prices(b)
+	for i := range p {
+		if p[i].Role != q[i].Role || p[i].Price != q[i].Price {
+			return false
+		}
+	}
+	return true
 }
 
 type Category struct {
@@ -76,37 +168,141 @@ func (c *Category) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
 		}
 	}
 
-	err = e.EncodeToken(start.End())
-	if err != nil {
-		return err
+	// flushing is left to the top-level encoder in Canteen.Write
+	return e.EncodeToken(start.End())
+}
+
+// Equal reports whether c and o have the same name and equal meals; the order
+// of the meals is irrelevant
+func (c *Category) Equal(o *Category) bool {
+	if c.Name != o.Name || len(c.Meals) != len(o.Meals) {
+		return false
+	}
+	matched := make([]bool, len(o.Meals))
+	for i := range c.Meals {
+		found := false
+		for j := range o.Meals {
+			if !matched[j] && c.Meals[i].Equal(&o.Meals[j]) {
+				matched[j], found = true, true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
 	}
-	return e.Flush()
+	return true
 }
 
 type Day struct {
 	Date       string `xml:"date"`
+	Updated    string `xml:"-"` // "Stand" date shown on the page, if any
 	Categories []Category
 }
 
+// servedCategories returns the categories of the day that have meals; only
+// these are part of the feed
+func (d *Day) servedCategories() []*Category {
+	var categories []*Category
+	for i := range d.Categories {
+		if len(d.Categories[i].Meals) > 0 {
+			categories = append(categories, &d.Categories[i])
+		}
+	}
+	return categories
+}
+
+// renameSingleCategory renames the category of a day with only one served
+// category to name; days with several categories are left as they are
+func (d *Day) renameSingleCategory(name string) {
+	if categories := d.servedCategories(); len(categories) == 1 {
+		categories[0].Name = name
+	}
+}
+
+// dedupeMeals removes meals listed in an earlier category of the day as
+// well, merging their notes into the first occurrence; meals of the same
+// name at other prices are different offers and kept
+func (d *Day) dedupeMeals() {
+	type ref struct{ category, meal int }
+	var first []ref // meals of the earlier categories
+	for i := range d.Categories {
+		c := &d.Categories[i]
+		meals := c.Meals[:0]
+	meals:
+		for j := range c.Meals {
+			m := c.Meals[j]
+			for _, r := range first {
+				f := &d.Categories[r.category].Meals[r.meal]
+				if f.Name == m.Name && samePrices(f.Prices, m.Prices) {
+					for _, n := range m.Notes {
+						if !hasNote(f.Notes, n) {
+							f.Notes = append(f.Notes, n)
+						}
+					}
+					continue meals
+				}
+			}
+			meals = append(meals, m)
+		}
+		c.Meals = meals
+		for j := range meals {
+			first = append(first, ref{i, j})
+		}
+	}
+}
+
+func hasNote(notes []Note, n Note) bool {
+	for _, o := range notes {
+		if o == n {
+			return true
+		}
+	}
+	return false
+}
+
+// closed reports whether no category of the day has any meal
+func (d *Day) closed() bool {
+	return len(d.servedCategories()) == 0
+}
+
+// Equal reports whether d and o describe the same day; categories are
+// compared by name whatever their order, and categories without meals are
+// not part of the feed and therefore ignored
+func (d *Day) Equal(o *Day) bool {
+	if d.Date != o.Date {
+		return false
+	}
+
+	byName := func(categories []*Category) []*Category {
+		sort.SliceStable(categories, func(i, j int) bool { return categories[i].Name < categories[j].Name })
+		return categories
+	}
+	a, b := byName(d.servedCategories()), byName(o.servedCategories())
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
 func (d *Day) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
 	start.Name = xml.Name{Local: "day"}
 	start.Attr = []xml.Attr{xml.Attr{Name: xml.Name{Local: "date"}, Value: d.Date}}
 
-	// check if at least one meal exists
-	closed := true
-	for _, c := range d.Categories {
-		if len(c.Meals) > 0 {
-			closed = false
-			break
-		}
-	}
+	// the same categories decide about closed and get emitted
+	categories := d.servedCategories()
 
 	err := e.EncodeToken(start)
 	if err != nil {
 		return err
 	}
 
-	if closed {
+	if len(categories) == 0 {
 		err := e.Encode(struct {
 			XMLName xml.Name `xml:"closed"`
 		}{})
@@ -114,7 +310,7 @@ func (d *Day) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
 			return err
 		}
 	} else {
-		for _, c := range d.Categories {
+		for _, c := range categories {
 			err = e.Encode(c)
 			if err != nil {
 				return err
@@ -122,11 +318,8 @@ func (d *Day) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
 		}
 	}
 
-	err = e.EncodeToken(start.End())
-	if err != nil {
-		return err
-	}
-	return e.Flush()
+	// flushing is left to the top-level encoder in Canteen.Write
+	return e.EncodeToken(start.End())
 }
 
 type Availability string
@@ -141,6 +334,21 @@ func (a Availability) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
 
 type Times struct {
 	openingHours []string
+	typ          string // value of the type attribute, "opening" if empty
+}
+
+// openOn reports whether the hours have the canteen open on the weekday of
+// date (YYYY-MM-DD); unknown hours are never open
+func (times *Times) openOn(date string) bool {
+	if times == nil || len(times.openingHours) != 7 {
+		return false
+	}
+	t, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return false
+	}
+	// openingHours starts on Monday
+	return times.openingHours[(int(t.Weekday())+6)%7] != ""
 }
 
 func (times Times) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
@@ -150,9 +358,13 @@ func (times Times) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
 		panic("len(times.openingHours) != 7 and not empty")
 	}
 
+	typ := times.typ
+	if typ == "" {
+		typ = "opening"
+	}
 	start = xml.StartElement{
-		Name: xml.Name{"", "times"},
-		Attr: []xml.Attr{xml.Attr{Name: xml.Name{"", "type"}, Value: "opening"}},
+		Name: xml.Name{Local: "times"},
+		Attr: []xml.Attr{xml.Attr{Name: xml.Name{Local: "type"}, Value: typ}},
 	}
 	if err := e.EncodeToken(start); err != nil {
 		return err
@@ -161,12 +373,12 @@ func (times Times) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
 	for i, name := range [7]string{"monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday"} {
 		var attr xml.Attr
 		if times.openingHours[i] == "" {
-			attr = xml.Attr{Name: xml.Name{"", "closed"}, Value: "true"}
+			attr = xml.Attr{Name: xml.Name{Local: "closed"}, Value: "true"}
 		} else {
-			attr = xml.Attr{Name: xml.Name{"", "open"}, Value: times.openingHours[i]}
+			attr = xml.Attr{Name: xml.Name{Local: "open"}, Value: times.openingHours[i]}
 		}
 		startDay := xml.StartElement{
-			Name: xml.Name{"", name},
+			Name: xml.Name{Local: name},
 			Attr: []xml.Attr{attr},
 		}
 		if err := e.EncodeElement("", startDay); err != nil {
@@ -177,27 +389,80 @@ func (times Times) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
 	return e.EncodeToken(start.End())
 }
 
+// SemesterBreak is the note about differing opening hours during the
+// semester break; From and To are the ISO dates of the break if given
+type SemesterBreak struct {
+	Note string `json:"note"`
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
 type Canteen struct {
 	XMLName      xml.Name     `xml:"canteen"`
 	Name         string       `xml:"name,omitempty"`
 	Address      string       `xml:"address,omitempty"`
 	City         string       `xml:"city,omitempty"`
+	District     string       `xml:"-"` // Bezirk, not part of the OpenMensa format
 	Phone        string       `xml:"phone,omitempty"`
+	Fax          string       `xml:"-"` // not part of the OpenMensa format
 	Email        string       `xml:"email,omitempty"`
 	Location     *Location    `xml:"location,omitempty"`
 	Availability Availability `xml:"availability,omitemtpy"`
 	Times        *Times       `xml:"times,omitemtpy"`
-	Feeds        []Feed       `xml:",omitempty"`
-	Days         []Day
+	TodayStatus  string       `xml:"-"` // live banner, only valid on the day of the run
+	Closure      string       `xml:"-"` // banner of a long-term closure, if any
+	Sources      []string     `xml:"-"` // all source pages, the first is used for the feeds
+	// Accessibility is the page's barrier-free access statement, if any
+	Accessibility string   `xml:"-"`
+	Transit       []string `xml:"-"` // nearby public transport stops
+	Comment       string   `xml:"-"` // written as XML comment before the canteen
+	CompactNotes  bool     `xml:"-"` // non-standard: notes of meals as attribute, see Meal.MarshalXML
+	Operator      string   `xml:"-"` // external operator, empty if run by the studierendenWERK
+	Seats         int      `xml:"-"` // seating capacity, 0 if unknown
+	// SemesterBreak notes differing hours during the semester break
+	SemesterBreak *SemesterBreak `xml:"-"`
+	Feeds         []Feed         `xml:",omitempty"`
+	Days          []Day
+}
+
+// today returns the feed of c reduced to the day of anchor, without any day
+// if that is not part of c
+func (c *Canteen) today(anchor time.Time) *Canteen {
+	date := anchor.Format("2006-01-02")
+	today := &Canteen{Comment: c.Comment}
+	for _, d := range c.Days {
+		if d.Date == date {
+			today.Days = []Day{d}
+		}
+	}
+	return today
 }
 
 func (c *Canteen) Write(w io.Writer) error {
 	if _, err := io.WriteString(w, xmlHeader); err != nil {
 		return err
 	}
+	if c.Comment != "" {
+		// "--" must not occur within a comment
+		comment := strings.ReplaceAll(c.Comment, "--", "- -")
+		if _, err := io.WriteString(w, xmlIndent+"<!-- "+comment+" -->\n"); err != nil {
+			return err
+		}
+	}
+
+	for i := range c.Days {
+		for j := range c.Days[i].Categories {
+			meals := c.Days[i].Categories[j].Meals
+			for k := range meals {
+				meals[k].compact = c.CompactNotes
+			}
+		}
+	}
 
+	// xmlHeader ends with a newline and the encoder does not write one
+	// after </canteen>, which xmlFooter adds
 	enc := xml.NewEncoder(w)
-	enc.Indent("  ", "  ")
+	enc.Indent(xmlIndent, xmlIndent)
 	if err := enc.Encode(c); err != nil {
 		return err
 	}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestMealEqual(t *testing.T) {
+	meal := Meal{
+		Name:   "Schnitzel",
+		Notes:  []Note{"vegan", "bio"},
+		Prices: []Price{{Role: "student", Price: "1.95"}, {Role: "other", Price: "4.65"}},
+	}
+	for _, test := range []struct {
+		name  string
+		other Meal
+		equal bool
+	}{
+		{"same", meal, true},
+		{"reordered", Meal{
+			Name:   "Schnitzel",
+			Notes:  []Note{"bio", "vegan"},
+			Prices: []Price{{Role: "other", Price: "4.65"}, {Role: "student", Price: "1.95"}},
+		}, true},
+		{"name", Meal{Name: "Schnitzel (groß)", Notes: meal.Notes, Prices: meal.Prices}, false},
+		{"note", Meal{Name: "Schnitzel", Notes: []Note{"vegan", "MSC"}, Prices: meal.Prices}, false},
+		{"fewer notes", Meal{Name: "Schnitzel", Notes: []Note{"vegan"}, Prices: meal.Prices}, false},
+		{"price", Meal{Name: "Schnitzel", Notes: meal.Notes, Prices: []Price{{Role: "student", Price: "1.95"}, {Role: "other", Price: "4.95"}}}, false},
+		{"role", Meal{Name: "Schnitzel", Notes: meal.Notes, Prices: []Price{{Role: "employee", Price: "1.95"}, {Role: "other", Price: "4.65"}}}, false},
+	} {
+		if equal := meal.Equal(&test.other); equal != test.equal {
+			t.Errorf("%s: Equal is %v", test.name, equal)
+		}
+	}
+
+	soup := Meal{Name: "Suppe", Prices: []Price{{Role: "student", Price: "0.95"}}}
+	category := Category{Name: "Essen", Meals: []Meal{meal, soup}}
+	for _, test := range []struct {
+		name  string
+		other Category
+		equal bool
+	}{
+		{"same category", category, true},
+		{"reordered meals", Category{Name: "Essen", Meals: []Meal{soup, meal}}, true},
+		{"renamed category", Category{Name: "Aktionen", Meals: category.Meals}, false},
+		{"missing meal", Category{Name: "Essen", Meals: []Meal{meal}}, false},
+		{"duplicate meal", Category{Name: "Essen", Meals: []Meal{meal, meal}}, false},
+	} {
+		if equal := category.Equal(&test.other); equal != test.equal {
+			t.Errorf("%s: Equal is %v", test.name, equal)
+		}
+	}
+
+	salads := Category{Name: "Salate", Meals: []Meal{{Name: "Salat"}}}
+	day := Day{Date: "2026-10-14", Categories: []Category{category, salads}}
+	for _, test := range []struct {
+		name  string
+		other Day
+		equal bool
+	}{
+		{"same day", day, true},
+		{"reordered categories", Day{Date: "2026-10-14", Categories: []Category{salads, {Name: "Essen", Meals: []Meal{soup, meal}}}}, true},
+		{"empty category", Day{Date: "2026-10-14", Categories: []Category{category, {Name: "Desserts"}, salads}}, true},
+		{"other date", Day{Date: "2026-10-15", Categories: day.Categories}, false},
+		{"missing category", Day{Date: "2026-10-14", Categories: []Category{category}}, false},
+		{"other meal", Day{Date: "2026-10-14", Categories: []Category{category, {Name: "Salate", Meals: []Meal{{Name: "Rohkost"}}}}}, false},
+	} {
+		if equal := day.Equal(&test.other); equal != test.equal {
+			t.Errorf("%s: Equal is %v", test.name, equal)
+		}
+	}
+}
+
+func TestDedupeMeals(t *testing.T) {
+	meal := Meal{Name: "Schnitzel", Notes: []Note{"bio"}, Prices: []Price{{Role: "student", Price: "1.95"}}}
+	cheaper := Meal{Name: "Schnitzel", Notes: meal.Notes, Prices: []Price{{Role: "student", Price: "1.45"}}}
+	vegan := Meal{Name: "Schnitzel", Notes: []Note{"bio", "vegan"}, Prices: meal.Prices}
+	day := Day{Categories: []Category{
+		{Name: "Essen", Meals: []Meal{meal}},
+		{Name: "Vegan", Meals: []Meal{vegan, cheaper}},
+	}}
+	day.dedupeMeals()
+
+	merged := Meal{Name: "Schnitzel", Notes: []Note{"bio", "vegan"}, Prices: meal.Prices}
+	if meals := day.Categories[0].Meals; len(meals) != 1 || !meals[0].Equal(&merged) {
+		t.Errorf("first category: %+v, want the notes merged into %+v", meals, merged)
+	}
+	if meals := day.Categories[1].Meals; len(meals) != 1 || !meals[0].Equal(&cheaper) {
+		t.Errorf("second category keeps %+v, want only the meal at other prices", meals)
+	}
+}
+
+// testDocument is the golden of the canteen written by TestCanteenWrite
+const testDocument = `<?xml version="1.0" encoding="UTF-8"?>
+<openmensa version="2.1"
+           xmlns="http://openmensa.org/open-mensa-v2"
+           xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"
+           xsi:schemaLocation="http://openmensa.org/open-mensa-v2 http://openmensa.org/open-mensa-v2.xsd">
+  <!-- a - - comment -->
+  <canteen>
+    <name>Mensa 1</name>
+    <city>Berlin</city>
+    <location latitude="52.5" longitude="13.3"></location>
+    <feed name="full">
+      <url>https://example.org/1.xml</url>
+    </feed>
+    <day date="2026-10-14">
+      <category name="Essen">
+        <meal>
+          <name>Linsen &amp; Reis</name>
+          <note>vegan</note>
+          <price role="student">1.55</price>
+        </meal>
+      </category>
+    </day>
+  </canteen>
+</openmensa>
+`
+
+// TestCanteenWrite checks the full document of Canteen.Write against
+// its golden and that the canteen sits within the <openmensa> root of the
+// well-formed document
+func TestCanteenWrite(t *testing.T) {
+	c := &Canteen{
+		Name:     "Mensa 1",
+		City:     "Berlin",
+		Location: &Location{Latitude: "52.5", Longitude: "13.3"},
+		Comment:  "a -- comment",
+		Feeds:    []Feed{{Name: "full", Url: "https://example.org/1.xml"}},
+		Days: []Day{{Date: "2026-10-14", Categories: []Category{
+			{Name: "Essen", Meals: []Meal{{
+				Name:   "Linsen & Reis",
+				Notes:  []Note{"vegan"},
+				Prices: []Price{{Price: "1.55", Role: "student"}},
+			}}},
+			{Name: "Leer"},
+		}}},
+	}
+	var b strings.Builder
+	if err := c.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != testDocument {
+		t.Errorf("document:\n%s", b.String())
+	}
+
+	var path []string
+	var paths []string
+	dec := xml.NewDecoder(strings.NewReader(b.String()))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("document is not well-formed: %v", err)
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			if tok.Name.Space != "http://openmensa.org/open-mensa-v2" {
+				t.Errorf("<%s> in namespace %q", tok.Name.Local, tok.Name.Space)
+			}
+			path = append(path, tok.Name.Local)
+			paths = append(paths, strings.Join(path, "/"))
+		case xml.EndElement:
+			path = path[:len(path)-1]
+		}
+	}
+	want := "openmensa,openmensa/canteen,openmensa/canteen/name"
+	if got := strings.Join(paths[:3], ","); got != want {
+		t.Errorf("nesting %s, want %s", got, want)
+	}
+	if got := paths[len(paths)-1]; got != "openmensa/canteen/day/category/meal/price" {
+		t.Errorf("last element %s", got)
+	}
+}
+
+// TestDayCategories checks the output of days with empty categories among
+// served ones, which keep their order, and with only empty ones
+func TestDayCategories(t *testing.T) {
+	meal := func(name string) []Meal {
+		return []Meal{{Name: name, Prices: []Price{{Price: "2.50", Role: "student"}}}}
+	}
+	for _, test := range []struct {
+		day  Day
+		want string
+	}{
+		{
+			Day{Date: "2026-10-14", Categories: []Category{
+				{Name: "Vorspeisen"},
+				{Name: "Essen", Meals: meal("Linsen")},
+				{Name: "Aktionen"},
+				{Name: "Beilagen", Meals: meal("Reis")},
+			}},
+			`<day date="2026-10-14">
+  <category name="Essen">
+    <meal>
+      <name>Linsen</name>
+      <price role="student">2.50</price>
+    </meal>
+  </category>
+  <category name="Beilagen">
+    <meal>
+      <name>Reis</name>
+      <price role="student">2.50</price>
+    </meal>
+  </category>
+</day>`,
+		},
+		{
+			Day{Date: "2026-10-15", Categories: []Category{{Name: "Essen"}, {Name: "Beilagen"}}},
+			`<day date="2026-10-15">
+  <closed></closed>
+</day>`,
+		},
+	} {
+		var b strings.Builder
+		enc := xml.NewEncoder(&b)
+		enc.Indent("", "  ")
+		if err := enc.Encode(&test.day); err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != test.want {
+			t.Errorf("day %s:\n%s\nwant:\n%s", test.day.Date, b.String(), test.want)
+		}
+		if closed := strings.Contains(test.want, "<closed>"); test.day.closed() != closed {
+			t.Errorf("day %s: closed() %v", test.day.Date, !closed)
+		}
+	}
+}
+
+// TestCompactNotes compares the standard and the compact note form of the
+// same canteen: the compact one is smaller and carries the same notes
+func TestCompactNotes(t *testing.T) {
+	write := func(compact bool) string {
+		c := &Canteen{CompactNotes: compact, Days: []Day{{Date: "2026-10-14", Categories: []Category{
+			{Name: "Essen", Meals: []Meal{
+				{Name: "Linsen", Notes: []Note{"vegan", "bio", "Gluten"}, Prices: []Price{{Price: "1.55", Role: "student"}}},
+				{Name: "Reis"},
+			}},
+		}}}}
+		var b strings.Builder
+		if err := c.Write(&b); err != nil {
+			t.Fatal(err)
+		}
+		return b.String()
+	}
+	standard, compact := write(false), write(true)
+	flat := func(doc string) string { return strings.Join(strings.Fields(doc), "") }
+	if len(compact) >= len(standard) {
+		t.Errorf("compact form has %d bytes, standard %d", len(compact), len(standard))
+	}
+	if !strings.Contains(flat(standard), "<note>vegan</note><note>bio</note><note>Gluten</note>") {
+		t.Errorf("standard form:\n%s", standard)
+	}
+	if strings.Contains(compact, "<note>") || !strings.Contains(compact, `<meal notes="vegan; bio; Gluten">`) {
+		t.Errorf("compact form:\n%s", compact)
+	}
+	if !strings.Contains(flat(compact), "<meal><name>Reis</name>") {
+		t.Errorf("meal without notes in compact form:\n%s", compact)
+	}
+}
+
+// TestPriceOrder checks that prices are written in the canonical role order
+// whatever order they were parsed in
+func TestPriceOrder(t *testing.T) {
+	want := `<meal><name>Linsen</name><price role="student">1.55</price><price role="pupil">1.60</price><price role="employee">2.90</price><price role="other">3.80</price></meal>`
+	for _, prices := range [][]Price{
+		{{Price: "1.55", Role: "student"}, {Price: "1.60", Role: "pupil"}, {Price: "2.90", Role: "employee"}, {Price: "3.80", Role: "other"}},
+		{{Price: "3.80", Role: "other"}, {Price: "2.90", Role: "employee"}, {Price: "1.60", Role: "pupil"}, {Price: "1.55", Role: "student"}},
+		{{Price: "2.90", Role: "employee"}, {Price: "1.55", Role: "student"}, {Price: "3.80", Role: "other"}, {Price: "1.60", Role: "pupil"}},
+	} {
+		meal := Meal{Name: "Linsen", Prices: prices}
+		data, err := xml.Marshal(meal)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("prices %v written as %s", prices, data)
+		}
+		if meal.Prices[0].Role != prices[0].Role {
+			t.Errorf("writing reordered the prices of the meal")
+		}
+	}
+}